@@ -0,0 +1,580 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+)
+
+// 下载格式，format 参数；Safari 会自动解压 ZIP，可以改用 tar
+const (
+	formatZip    = "zip"
+	formatTar    = "tar"
+	formatTarGz  = "tar.gz"
+	formatTarZst = "tar.zst"
+)
+
+func parseArchiveFormat(s string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "", "zip":
+		return formatZip, nil
+	case "tar":
+		return formatTar, nil
+	case "tar.gz", "tgz":
+		return formatTarGz, nil
+	case "tar.zst", "tar.zstd", "tzst":
+		return formatTarZst, nil
+	}
+	return "", fmt.Errorf("unsupported format %q (zip, tar, tar.gz or tar.zst)", s)
+}
+
+// level 参数：none（不压缩，ZIP 就是能续传的那种）、fast、best、1-9，不带是默认级别；
+// 老的 mode=store 等同于 level=none
+func parseCompressionLevel(level, mode string) (int, error) {
+	if mode == "store" {
+		return flate.NoCompression, nil
+	}
+	switch s := strings.ToLower(strings.TrimSpace(level)); s {
+	case "", "default":
+		return flate.DefaultCompression, nil
+	case "none", "store", "0":
+		return flate.NoCompression, nil
+	case "fast", "fastest":
+		return flate.BestSpeed, nil
+	case "best":
+		return flate.BestCompression, nil
+	default:
+		if n, err := strconv.Atoi(s); err == nil && n >= 1 && n <= 9 {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid compression level %q (none, fast, best or 1-9)", level)
+}
+
+// 已经压缩过的格式再 deflate 只是白费 CPU，直接存
+var storedExts = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".heic": true, ".heif": true, ".avif": true,
+	".mp4": true, ".m4v": true, ".mov": true, ".mkv": true, ".webm": true, ".avi": true, ".wmv": true, ".flv": true,
+	".mp3": true, ".m4a": true, ".aac": true, ".ogg": true, ".opus": true, ".flac": true, ".wma": true,
+	".zip": true, ".gz": true, ".tgz": true, ".bz2": true, ".xz": true, ".zst": true, ".7z": true, ".rar": true,
+	".jar": true, ".apk": true, ".ipa": true, ".docx": true, ".xlsx": true, ".pptx": true, ".epub": true,
+	".woff": true, ".woff2": true, ".dmg": true,
+}
+
+// 扩展名认不出来时看文件头（http.DetectContentType 的前 512 字节）
+func incompressibleType(ct string) bool {
+	switch ct {
+	case "image/bmp", "image/x-icon", "image/svg+xml", "audio/wave", "audio/aiff":
+		return false
+	case "application/zip", "application/x-gzip", "application/x-rar-compressed", "application/ogg", "font/woff", "font/woff2":
+		return true
+	}
+	return strings.HasPrefix(ct, "image/") || strings.HasPrefix(ct, "video/") || strings.HasPrefix(ct, "audio/")
+}
+
+func parseBoolParam(s string) bool {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
+
+// 下载的文件名：去掉调用方可能带的扩展名，再按格式加上
+func archiveFilename(base, format string) string {
+	base = strings.NewReplacer("/", "_", "\\", "_", "\"", "_").Replace(base)
+	lower := strings.ToLower(base)
+	for _, ext := range []string{".zip", ".tar.gz", ".tgz", ".tar.zst", ".tar"} {
+		if strings.HasSuffix(lower, ext) && len(base) > len(ext) {
+			base = base[:len(base)-len(ext)]
+			break
+		}
+	}
+	return base + "." + format
+}
+
+// 打不进包的文件（读不了、不是普通文件、下载途中没了）不再悄悄漏掉：
+// 记下来打印在服务端，包的最后附一个 _SKIPPED_FILES.txt；strict=1 时直接报错
+const skippedManifestName = "_SKIPPED_FILES.txt"
+
+type skippedFile struct {
+	Name   string // 包里的路径
+	Reason string
+}
+
+type archiveCollector struct {
+	entries   []*archiveEntry
+	skipped   []skippedFile
+	keepLinks bool // tar 原样保留软链接，ZIP 跟到目标
+	strict    bool
+}
+
+// 错误信息里去掉服务端的绝对路径，只留原因
+func skipReason(err error) string {
+	var pe *fs.PathError
+	if errors.As(err, &pe) {
+		return pe.Err.Error()
+	}
+	return err.Error()
+}
+
+func (c *archiveCollector) skip(name string, err error) {
+	c.skipped = append(c.skipped, skippedFile{Name: name, Reason: skipReason(err)})
+	fmt.Printf("打包时跳过: %s (%s)\n", name, skipReason(err))
+}
+
+// 把磁盘上的一个文件或文件夹收进包里，name 是它在包里的路径；
+// name 为空表示只收文件夹里面的内容（整个文件夹下载）
+func (c *archiveCollector) add(full, name string) {
+	_ = filepath.WalkDir(full, func(p string, d fs.DirEntry, err error) error {
+		rel, rerr := filepath.Rel(full, p)
+		if rerr != nil {
+			return nil
+		}
+		entryName := path.Join(name, filepath.ToSlash(rel))
+		if err != nil {
+			// 文件夹读不了时这里会再来一次，文件夹本身已经收了
+			c.skip(entryName, err)
+			return nil
+		}
+		if p != full && isInternalName(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if entryName == "." || entryName == "" {
+			return nil
+		}
+		stat := os.Stat
+		if c.keepLinks {
+			stat = os.Lstat
+		}
+		info, err := stat(p)
+		if err != nil {
+			c.skip(entryName, err)
+			return nil
+		}
+		e := &archiveEntry{name: entryName, mode: info.Mode(), modTime: info.ModTime()}
+		switch {
+		case d.IsDir():
+			e.name += "/"
+		case info.Mode()&fs.ModeSymlink != 0:
+			if e.link, err = os.Readlink(p); err != nil {
+				c.skip(entryName, err)
+				return nil
+			}
+		case info.Mode().IsRegular():
+			// 先试着打开一次，读不了的现在就记下来，不压缩的 ZIP 要事先定好布局
+			f, err := os.Open(p)
+			if err != nil {
+				c.skip(entryName, err)
+				return nil
+			}
+			_ = f.Close()
+			e.path = p
+			e.size = info.Size()
+		default:
+			c.skip(entryName, errors.New("not a regular file"))
+			return nil
+		}
+		c.entries = append(c.entries, e)
+		return nil
+	})
+}
+
+// 跳过的文件清单，放在包的最后；时间用包里最新的修改时间，同样的内容每次生成的字节一样
+func (c *archiveCollector) manifest() *archiveEntry {
+	if len(c.skipped) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	b.WriteString("These files could not be added to the archive:\n\n")
+	for _, s := range c.skipped {
+		fmt.Fprintf(&b, "%s: %s\n", s.Name, s.Reason)
+	}
+	e := &archiveEntry{name: skippedManifestName, data: []byte(b.String()), mode: 0644}
+	e.size = int64(len(e.data))
+	for _, x := range c.entries {
+		if x.modTime.After(e.modTime) {
+			e.modTime = x.modTime
+		}
+	}
+	e.crc, e.crcDone = crc32.ChecksumIEEE(e.data), true
+	return e
+}
+
+func (c *archiveCollector) skippedText() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "cannot add %d file(s) to the archive:\n", len(c.skipped))
+	for _, s := range c.skipped {
+		fmt.Fprintf(&b, "%s: %s\n", s.Name, s.Reason)
+	}
+	return b.String()
+}
+
+// 边发边打包时，发出去之后才遇到的问题：strict 模式直接断开连接，客户端拿到的是不完整的下载
+func (c *archiveCollector) lateSkip(name string, err error) {
+	c.skip(name, err)
+	if c.strict {
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// 按格式发送；ZIP 不压缩（level=none）时是能续传的版本（zipstore.go）
+func serveArchive(w http.ResponseWriter, r *http.Request, c *archiveCollector, filename, format string, level int) {
+	if c.strict && len(c.skipped) > 0 {
+		http.Error(w, c.skippedText(), http.StatusConflict)
+		return
+	}
+	switch format {
+	case formatZip:
+		if level == flate.NoCompression {
+			serveStoredZip(w, r, c, filename)
+			return
+		}
+		writeDeflateZip(w, c, filename, level)
+	default:
+		writeTar(w, c, filename, format, level)
+	}
+}
+
+// 边发边打包的格式大小事先不知道，跳过的个数放在 trailer 里
+const skippedTrailer = "X-Skipped-Files"
+
+// tar 保留权限、修改时间和软链接；.gz / .zst 边压缩边发送
+func writeTar(w http.ResponseWriter, c *archiveCollector, filename, format string, level int) {
+	var out io.WriteCloser
+	switch format {
+	case formatTarGz:
+		w.Header().Set("Content-Type", "application/gzip")
+		gw, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out = gw
+	case formatTarZst:
+		w.Header().Set("Content-Type", "application/zstd")
+		zw, err := zstd.NewWriter(w, zstd.WithEncoderLevel(zstdLevel(level)))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		out = zw
+	default:
+		w.Header().Set("Content-Type", "application/x-tar")
+		out = nopWriteCloser{w}
+	}
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Trailer", skippedTrailer)
+
+	tw := tar.NewWriter(out)
+	writeEntry := func(e *archiveEntry) error {
+		hdr := &tar.Header{Name: e.name, Mode: int64(e.mode.Perm()), ModTime: e.modTime}
+		var src io.Reader
+		switch {
+		case e.isDir():
+			hdr.Typeflag = tar.TypeDir
+		case e.link != "":
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.link
+		case e.data != nil:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = e.size
+			src = bytes.NewReader(e.data)
+		default:
+			// 头里要写大小，先打开，打不开就跳过这个文件
+			f, err := os.Open(e.path)
+			if err != nil {
+				c.lateSkip(e.name, err)
+				return nil
+			}
+			defer f.Close()
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = e.size
+			src = f
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if src == nil {
+			return nil
+		}
+		// 大小已经写进头里了，文件中途变了就只能断开，不能发一个坏包
+		_, err := io.CopyN(tw, src, e.size)
+		return err
+	}
+	for _, e := range c.entries {
+		if err := writeEntry(e); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if m := c.manifest(); m != nil {
+		if err := writeEntry(m); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if tw.Close() == nil {
+		_ = out.Close()
+	}
+	w.Header().Set(skippedTrailer, strconv.Itoa(len(c.skipped)))
+}
+
+// flate 的 0-9 对到 zstd 的四档
+func zstdLevel(level int) zstd.EncoderLevel {
+	switch {
+	case level < 0:
+		return zstd.SpeedDefault
+	case level <= 2:
+		return zstd.SpeedFastest
+	case level <= 5:
+		return zstd.SpeedDefault
+	case level <= 7:
+		return zstd.SpeedBetterCompression
+	}
+	return zstd.SpeedBestCompression
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+// 已经压缩过的格式直接存：先看扩展名，认不出来再看文件头。返回的 Reader 从文件开头读
+func chooseZipMethod(name string, f io.Reader) (uint16, io.Reader) {
+	if storedExts[strings.ToLower(path.Ext(name))] {
+		return zip.Store, f
+	}
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	src := io.MultiReader(bytes.NewReader(head[:n]), f)
+	if incompressibleType(http.DetectContentType(head[:n])) {
+		return zip.Store, src
+	}
+	return zip.Deflate, src
+}
+
+// 边压缩边发送，大小事先不知道。每个文件按扩展名 / 文件头决定存还是压，
+// 压缩用 klauspost/compress 的 flate，同样级别比标准库快不少；小文件在多个 worker 里并行压（zipparallel.go）
+func writeDeflateZip(w http.ResponseWriter, c *archiveCollector, filename string, level int) {
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("Trailer", skippedTrailer)
+
+	zw := zip.NewWriter(w)
+	zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(out, level)
+	})
+	writeEntry := func(e *archiveEntry) error {
+		// 带上修改时间和 Unix 权限（SetMode 会把创建系统标成 Unix），空文件夹也要有自己的条目；
+		// 名字不是纯 ASCII 时 archive/zip 会自动打上 UTF-8 标志
+		hdr := &zip.FileHeader{Name: e.name, Method: zip.Deflate, Modified: e.modTime}
+		hdr.SetMode(e.mode)
+		if e.isDir() {
+			hdr.Method = zip.Store
+			_, err := zw.CreateHeader(hdr)
+			return err
+		}
+		var src io.Reader = bytes.NewReader(e.data)
+		if e.data == nil {
+			f, err := os.Open(e.path)
+			if err != nil {
+				c.lateSkip(e.name, err)
+				return nil
+			}
+			defer f.Close()
+			hdr.Method, src = chooseZipMethod(e.name, f)
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(fw, src)
+		return err
+	}
+	pipe := startZipPipeline(c.entries, level)
+	defer pipe.stop()
+	for i, e := range c.entries {
+		job := pipe.wait(i)
+		if job == nil {
+			if err := writeEntry(e); err != nil {
+				panic(http.ErrAbortHandler)
+			}
+			continue
+		}
+		if job.openErr != nil {
+			c.lateSkip(e.name, job.openErr)
+			pipe.release(job)
+			continue
+		}
+		if job.err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		fw, err := zw.CreateRaw(job.hdr)
+		if err == nil {
+			_, err = job.buf.WriteTo(fw)
+		}
+		if err != nil {
+			panic(http.ErrAbortHandler)
+		}
+		pipe.release(job)
+	}
+	if m := c.manifest(); m != nil {
+		if err := writeEntry(m); err != nil {
+			panic(http.ErrAbortHandler)
+		}
+	}
+	if zw.Close() != nil {
+		panic(http.ErrAbortHandler)
+	}
+	w.Header().Set(skippedTrailer, strconv.Itoa(len(c.skipped)))
+}
+
+type selectionRequest struct {
+	Share  string   `json:"share"`
+	Paths  []string `json:"paths"`
+	Name   string   `json:"name"`
+	Mode   string   `json:"mode"`
+	Level  string   `json:"level"`
+	Format string   `json:"format"`
+	Strict bool     `json:"strict"`
+}
+
+// 选中的路径在包里的名字：相对于它们共同的上级目录，
+// 同一个文件夹里选的几项就是各自的名字，来自不同文件夹的会带上区分用的目录
+func selectionNames(rels []string) (string, []string) {
+	parent := path.Dir(rels[0])
+	for _, rel := range rels[1:] {
+		for parent != "." && !strings.HasPrefix(rel, parent+"/") {
+			parent = path.Dir(parent)
+		}
+	}
+	names := make([]string, len(rels))
+	for i, rel := range rels {
+		if parent == "." {
+			names[i] = rel
+		} else {
+			names[i] = strings.TrimPrefix(rel, parent+"/")
+		}
+	}
+	return parent, names
+}
+
+// POST /download-selection：把选中的多个文件 / 文件夹打成一个包。
+// 表单字段 share、paths（可以重复）、name、format、level、strict，或者同样字段的 JSON
+func handleDownloadSelection(w http.ResponseWriter, r *http.Request) {
+	if !isAuthed(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req selectionRequest
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "bad json", http.StatusBadRequest)
+			return
+		}
+	} else {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req = selectionRequest{Share: r.PostForm.Get("share"), Paths: r.PostForm["paths"], Name: r.PostForm.Get("name"), Mode: r.PostForm.Get("mode"), Level: r.PostForm.Get("level"), Format: r.PostForm.Get("format"), Strict: parseBoolParam(r.PostForm.Get("strict"))}
+	}
+	sh, err := findShare(req.Share)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	format, err := parseArchiveFormat(req.Format)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	level, err := parseCompressionLevel(req.Level, req.Mode)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// 规整路径、去重，已经选了上级目录的不再单独收
+	var rels []string
+	seen := make(map[string]bool)
+	for _, p := range req.Paths {
+		rel := path.Clean(strings.ReplaceAll(strings.TrimSpace(p), "\\", "/"))
+		rel = strings.TrimPrefix(rel, "/")
+		if rel == "." || rel == "" {
+			http.Error(w, "invalid path: "+p, http.StatusBadRequest)
+			return
+		}
+		full, err := joinSafe(sh.Path, rel)
+		if err != nil {
+			http.Error(w, "invalid path: "+p, http.StatusBadRequest)
+			return
+		}
+		if _, err := os.Stat(full); err != nil {
+			http.Error(w, "not found: "+p, http.StatusNotFound)
+			return
+		}
+		if !seen[rel] {
+			seen[rel] = true
+			rels = append(rels, rel)
+		}
+	}
+	if len(rels) == 0 {
+		http.Error(w, "no paths selected", http.StatusBadRequest)
+		return
+	}
+	sort.Strings(rels)
+	kept := rels[:0]
+	for _, rel := range rels {
+		covered := false
+		for dir := path.Dir(rel); dir != "." && !covered; dir = path.Dir(dir) {
+			covered = seen[dir]
+		}
+		if !covered {
+			kept = append(kept, rel)
+		}
+	}
+	rels = kept
+
+	parent, names := selectionNames(rels)
+	c := &archiveCollector{keepLinks: format != formatZip, strict: req.Strict}
+	for i, name := range names {
+		full, _ := joinSafe(sh.Path, rels[i])
+		c.add(full, name)
+	}
+
+	filename := strings.TrimSpace(req.Name)
+	if filename == "" {
+		switch {
+		case len(rels) == 1:
+			filename = path.Base(rels[0])
+		case parent != ".":
+			filename = path.Base(parent)
+		default:
+			filename = sh.Name
+		}
+	}
+	serveArchive(w, r, c, archiveFilename(filename, format), format, level)
+}
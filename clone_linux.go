@@ -0,0 +1,16 @@
+//go:build linux
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// ioctl FICLONE：btrfs、XFS 等支持 reflink 的文件系统上让两个文件共享数据块
+const ficlone = 0x40049409
+
+func cloneFile(dst, src *os.File) bool {
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, dst.Fd(), ficlone, src.Fd())
+	return errno == 0
+}
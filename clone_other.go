@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "os"
+
+// 其他系统不做 reflink，直接拷贝
+func cloneFile(dst, src *os.File) bool {
+	return false
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const (
+	defaultPort      = "8080"
+	defaultPassword  = "0000"
+	defaultTrashDays = 30
+)
+
+// 环境变量名，和命令行参数一一对应
+const (
+	envConfig       = "FILETRANSFER_CONFIG"
+	envPort         = "FILETRANSFER_PORT"
+	envPassword     = "FILETRANSFER_PASSWORD"
+	envPasswordFile = "FILETRANSFER_PASSWORD_FILE"
+	envRoot         = "FILETRANSFER_ROOT"
+	envMaxUpload    = "FILETRANSFER_MAX_UPLOAD_SIZE"
+	envOnConflict   = "FILETRANSFER_ON_CONFLICT"
+	envZipWorkers   = "FILETRANSFER_ZIP_WORKERS"
+	envTrashDays    = "FILETRANSFER_TRASH_DAYS"
+)
+
+// 启动参数：命令行 > 环境变量 > 配置文件 > 交互输入 > 默认值
+type options struct {
+	port          string
+	password      string
+	shares        []*share
+	maxUploadSize int64
+	onConflict    conflictPolicy
+	zipWorkers    int // 0 表示按 CPU 核数
+	trashDays     int // 0 表示不用回收站
+}
+
+// 配置文件内容，JSON 格式，允许整行 // 注释
+type fileConfig struct {
+	Port          int     `json:"port,omitempty"`
+	Password      string  `json:"password,omitempty"`
+	PasswordFile  string  `json:"passwordFile,omitempty"`
+	Root          string  `json:"root,omitempty"`
+	Shares        []share `json:"shares,omitempty"`
+	MaxUploadSize string  `json:"maxUploadSize,omitempty"`
+	OnConflict    string  `json:"onConflict,omitempty"`
+	ZipWorkers    int     `json:"zipWorkers,omitempty"`
+	TrashDays     *int    `json:"trashDays,omitempty"` // 0 有意义（不用回收站），所以用指针区分没写
+}
+
+func envOr(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return strings.TrimSpace(v)
+	}
+	return fallback
+}
+
+// stdin 是终端才问问题，脚本 / systemd / 管道启动时直接用默认值。
+// 不能只看是不是字符设备，/dev/null（systemd 默认的 StandardInput）也是
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// 读密码文件，只去掉结尾换行，方便 echo / secret 文件直接用
+func readPasswordFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read password file: %w", err)
+	}
+	pwd := strings.TrimRight(string(b), "\r\n")
+	if pwd == "" {
+		return "", fmt.Errorf("password file %s is empty", path)
+	}
+	return pwd, nil
+}
+
+// 默认配置文件位置，例如 ~/.config/filetransfer/config.json
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "filetransfer", "config.json")
+}
+
+// Linux 等桌面环境下读 ~/.config/user-dirs.dirs 里的 XDG_DESKTOP_DIR，
+// 中文系统的桌面经常叫 ~/桌面 而不是 ~/Desktop
+func xdgDesktopDir(home string) string {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return ""
+	}
+	if v := strings.TrimSpace(os.Getenv("XDG_DESKTOP_DIR")); v != "" {
+		return v
+	}
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		configHome = filepath.Join(home, ".config")
+	}
+	data, err := os.ReadFile(filepath.Join(configHome, "user-dirs.dirs"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, val, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok || key != "XDG_DESKTOP_DIR" {
+			continue
+		}
+		val = strings.Trim(strings.TrimSpace(val), `"`)
+		val = strings.Replace(val, "$HOME", home, 1)
+		if !filepath.IsAbs(val) || filepath.Clean(val) == filepath.Clean(home) {
+			// 按规范指向 $HOME 表示"没有桌面目录"
+			return ""
+		}
+		return val
+	}
+	return ""
+}
+
+// 默认共享放在桌面下；没有桌面（无图形界面的服务器）就放在家目录下
+func defaultRootBase() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot find home directory (%v), use --root to choose a folder", err)
+	}
+	candidates := []string{xdgDesktopDir(home), filepath.Join(home, "Desktop")}
+	for _, dir := range candidates {
+		if dir == "" {
+			continue
+		}
+		if st, err := os.Stat(dir); err == nil && st.IsDir() {
+			return dir, nil
+		}
+	}
+	return home, nil
+}
+
+// 启动时确保共享目录存在；可写共享再实际写一次，权限问题启动时就暴露
+func ensureShareDir(sh *share) error {
+	if sh.ReadOnly {
+		st, err := os.Stat(sh.Path)
+		if err != nil {
+			return fmt.Errorf("share %q: %s does not exist or cannot be accessed: %v", sh.Name, sh.Path, err)
+		}
+		if !st.IsDir() {
+			return fmt.Errorf("share %q: %s is not a directory", sh.Name, sh.Path)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(sh.Path, 0755); err != nil {
+		return fmt.Errorf("share %q: cannot create %s: %v (use --root or the config file to choose another folder)", sh.Name, sh.Path, err)
+	}
+	f, err := os.CreateTemp(sh.Path, tempFilePrefix+"write-test-*")
+	if err != nil {
+		return fmt.Errorf("share %q: %s is not writable: %v (use --share-ro to share it read-only)", sh.Name, sh.Path, err)
+	}
+	name := f.Name()
+	_ = f.Close()
+	_ = os.Remove(name)
+	return nil
+}
+
+// 展开开头的 ~/，配置文件里写路径更顺手
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`) {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// 去掉整行 // 注释，保留换行让 JSON 报错的行号还能对上
+func stripLineComments(data []byte) []byte {
+	lines := bytes.Split(data, []byte("\n"))
+	for i, line := range lines {
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("//")) {
+			lines[i] = nil
+		}
+	}
+	return bytes.Join(lines, []byte("\n"))
+}
+
+func lineOf(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// 读配置文件；文件不存在返回空配置，不算错误
+func readConfigFile(path string) (cfg fileConfig, err error) {
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fileConfig{}, nil
+	}
+	if err != nil {
+		return fileConfig{}, fmt.Errorf("config %s: %w", path, err)
+	}
+	data := stripLineComments(raw)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cfg); err != nil {
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			return fileConfig{}, fmt.Errorf("config %s: line %d: %v", path, lineOf(data, syntaxErr.Offset), err)
+		case errors.As(err, &typeErr):
+			return fileConfig{}, fmt.Errorf("config %s: line %d: field %q has wrong type", path, lineOf(data, typeErr.Offset), typeErr.Field)
+		default:
+			return fileConfig{}, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	if cfg.Port != 0 {
+		if err := validatePort(strconv.Itoa(cfg.Port)); err != nil {
+			return fileConfig{}, fmt.Errorf("config %s: %v", path, err)
+		}
+	}
+	if _, err := parseSize(cfg.MaxUploadSize); err != nil {
+		return fileConfig{}, fmt.Errorf("config %s: maxUploadSize: %v", path, err)
+	}
+	if _, err := parseConflictPolicy(cfg.OnConflict); err != nil {
+		return fileConfig{}, fmt.Errorf("config %s: onConflict: %v", path, err)
+	}
+	if cfg.ZipWorkers < 0 {
+		return fileConfig{}, fmt.Errorf("config %s: zipWorkers must not be negative", path)
+	}
+	if cfg.TrashDays != nil && *cfg.TrashDays < 0 {
+		return fileConfig{}, fmt.Errorf("config %s: trashDays must not be negative", path)
+	}
+	cfg.Root = expandHome(strings.TrimSpace(cfg.Root))
+	cfg.PasswordFile = expandHome(strings.TrimSpace(cfg.PasswordFile))
+	return cfg, nil
+}
+
+// 解析 "512M"、"10G"、"1048576" 这样的大小，0 或空表示不限制
+func parseSize(text string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(text))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+	if s == "" {
+		return 0, nil
+	}
+	mult := int64(1)
+	switch s[len(s)-1] {
+	case 'K':
+		mult = 1 << 10
+	case 'M':
+		mult = 1 << 20
+	case 'G':
+		mult = 1 << 30
+	case 'T':
+		mult = 1 << 40
+	}
+	if mult != 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 || n > (1<<62)/mult {
+		return 0, fmt.Errorf("invalid size %q (examples: 512M, 10G)", text)
+	}
+	return n * mult, nil
+}
+
+func validatePort(port string) error {
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q: must be a number between 1 and 65535", port)
+	}
+	return nil
+}
+
+// root 已存在时必须是可读的文件夹；不存在的话启动时再创建
+func validateRoot(root string) error {
+	st, err := os.Stat(root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("root %s: %w", root, err)
+	}
+	if !st.IsDir() {
+		return fmt.Errorf("root %s is not a directory", root)
+	}
+	if _, err := os.ReadDir(root); err != nil {
+		return fmt.Errorf("root %s is not readable: %w", root, err)
+	}
+	return nil
+}
+
+// 带注释的配置文件内容，init 和交互保存都用它
+func renderConfigFile(cfg fileConfig) []byte {
+	q := func(s string) string {
+		b, _ := json.Marshal(s)
+		return string(b)
+	}
+	port := cfg.Port
+	if port == 0 {
+		port, _ = strconv.Atoi(defaultPort)
+	}
+	var b strings.Builder
+	b.WriteString("// FileTransfer 配置文件：JSON 格式，整行 // 开头的是注释。\n")
+	b.WriteString("// 优先级：命令行参数 > 环境变量 > 本文件 > 交互输入 > 默认值。\n")
+	b.WriteString("{\n")
+	b.WriteString("  // 监听端口，1-65535\n")
+	fmt.Fprintf(&b, "  \"port\": %d,\n", port)
+	b.WriteString("\n")
+	b.WriteString("  // 登录密码；留空则看 passwordFile，再留空就启动时询问\n")
+	fmt.Fprintf(&b, "  \"password\": %s,\n", q(cfg.Password))
+	b.WriteString("\n")
+	b.WriteString("  // 从文件读密码（去掉结尾换行），password 为空时才用\n")
+	fmt.Fprintf(&b, "  \"passwordFile\": %s,\n", q(cfg.PasswordFile))
+	b.WriteString("\n")
+	b.WriteString("  // 共享的根目录，支持 ~/ 开头；留空为 桌面/Myfiles（配置了 shares 且 root 留空时不共享它）\n")
+	fmt.Fprintf(&b, "  \"root\": %s,\n", q(cfg.Root))
+	b.WriteString("\n")
+	b.WriteString("  // 额外的命名共享，例如：\n")
+	b.WriteString("  //   {\"name\": \"inbox\", \"path\": \"~/inbox\"},\n")
+	b.WriteString("  //   {\"name\": \"media\", \"path\": \"/mnt/media\", \"readOnly\": true}\n")
+	if len(cfg.Shares) == 0 {
+		b.WriteString("  \"shares\": [],\n")
+	} else {
+		b.WriteString("  \"shares\": [\n")
+		for i, sh := range cfg.Shares {
+			line, _ := json.Marshal(sh)
+			b.WriteString("    ")
+			b.Write(line)
+			if i < len(cfg.Shares)-1 {
+				b.WriteString(",")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("  ],\n")
+	}
+	b.WriteString("\n")
+	b.WriteString("  // /upload 单次请求和断点续传单个文件的大小上限，例如 \"512M\"、\"10G\"；留空不限制\n")
+	fmt.Fprintf(&b, "  \"maxUploadSize\": %s,\n", q(cfg.MaxUploadSize))
+	b.WriteString("\n")
+	b.WriteString("  // 上传时遇到同名文件：overwrite 覆盖、rename 另存为 \"name (1).ext\"、skip 跳过、fail 报错；留空为 rename\n")
+	fmt.Fprintf(&b, "  \"onConflict\": %s,\n", q(cfg.OnConflict))
+	b.WriteString("\n")
+	b.WriteString("  // 打包 ZIP 时并行压缩的线程数，1 为单线程；0 为 CPU 核数\n")
+	fmt.Fprintf(&b, "  \"zipWorkers\": %d,\n", cfg.ZipWorkers)
+	b.WriteString("\n")
+	b.WriteString("  // 删除的文件在回收站里保留几天，过期自动清掉；0 表示不用回收站，删除就是真删\n")
+	trashDays := defaultTrashDays
+	if cfg.TrashDays != nil {
+		trashDays = *cfg.TrashDays
+	}
+	fmt.Fprintf(&b, "  \"trashDays\": %d\n", trashDays)
+	b.WriteString("}\n")
+	return []byte(b.String())
+}
+
+func writeConfigFile(path string, cfg fileConfig) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// 里面可能有明文密码，只给自己读写
+	return os.WriteFile(path, renderConfigFile(cfg), 0600)
+}
+
+// init 子命令：写一份带注释的默认配置
+func runInit(args []string) error {
+	fset := flag.NewFlagSet("FileTransfer init", flag.ContinueOnError)
+	configPath := fset.String("config", envOr(envConfig, defaultConfigPath()), "config file to write (env "+envConfig+")")
+	force := fset.Bool("force", false, "overwrite an existing config file")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if *configPath == "" {
+		return fmt.Errorf("cannot determine config directory, use --config")
+	}
+	if _, err := os.Stat(*configPath); err == nil && !*force {
+		return fmt.Errorf("config %s already exists (use --force to overwrite)", *configPath)
+	}
+	if err := writeConfigFile(*configPath, fileConfig{}); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+	fmt.Println("已写入配置文件:", *configPath)
+	return nil
+}
+
+func askYesNo(reader *bufio.Reader, question string) bool {
+	fmt.Print(question)
+	line, _ := reader.ReadString('\n')
+	line = strings.TrimSpace(line)
+	return strings.EqualFold(line, "y") || strings.EqualFold(line, "yes")
+}
+
+func loadOptions(args []string) (options, error) {
+	fset := flag.NewFlagSet("FileTransfer", flag.ContinueOnError)
+	configPath := fset.String("config", envOr(envConfig, defaultConfigPath()), "config file (env "+envConfig+")")
+	port := fset.String("port", envOr(envPort, ""), "listen port (env "+envPort+")")
+	password := fset.String("password", envOr(envPassword, ""), "login password (env "+envPassword+")")
+	passwordFile := fset.String("password-file", envOr(envPasswordFile, ""), "read login password from file (env "+envPasswordFile+")")
+	root := fset.String("root", envOr(envRoot, ""), "root folder to share (env "+envRoot+")")
+	maxUpload := fset.String("max-upload-size", envOr(envMaxUpload, ""), "upload size limit such as 512M or 10G, empty = unlimited (env "+envMaxUpload+")")
+	onConflict := fset.String("on-conflict", envOr(envOnConflict, ""), "default policy for existing files on upload: overwrite, rename, skip or fail (env "+envOnConflict+")")
+	trashDaysText := fset.String("trash-days", envOr(envTrashDays, ""), "days deleted files stay in the trash, 0 = delete immediately (env "+envTrashDays+", default "+strconv.Itoa(defaultTrashDays)+")")
+	zipWorkersText := fset.String("zip-workers", envOr(envZipWorkers, ""), "parallel compression workers for ZIP downloads, 0 = number of CPUs (env "+envZipWorkers+")")
+	var flagShares []share
+	fset.Var(shareFlag{list: &flagShares}, "share", "extra writable share as name=path (repeatable)")
+	fset.Var(shareFlag{list: &flagShares, readOnly: true}, "share-ro", "extra read-only share as name=path (repeatable)")
+	fset.Usage = func() {
+		fmt.Fprintln(fset.Output(), "Usage: FileTransfer [flags]\n       FileTransfer init [--config path] [--force]\n\nFlags:")
+		fset.PrintDefaults()
+	}
+	if err := fset.Parse(args); err != nil {
+		return options{}, err
+	}
+	if fset.NArg() > 0 {
+		return options{}, fmt.Errorf("unexpected argument: %s", fset.Arg(0))
+	}
+
+	var cfg fileConfig
+	if *configPath != "" {
+		var err error
+		cfg, err = readConfigFile(*configPath)
+		if err != nil {
+			return options{}, err
+		}
+	}
+
+	opts := options{
+		port:     strings.TrimSpace(*port),
+		password: *password,
+	}
+	rootPath := strings.TrimSpace(*root)
+	if opts.port == "" && cfg.Port != 0 {
+		opts.port = strconv.Itoa(cfg.Port)
+	}
+	if opts.password == "" && *passwordFile == "" {
+		opts.password = cfg.Password
+	}
+	if opts.password == "" {
+		pwdFile := *passwordFile
+		if pwdFile == "" {
+			pwdFile = cfg.PasswordFile
+		}
+		if pwdFile != "" {
+			pwd, err := readPasswordFile(pwdFile)
+			if err != nil {
+				return options{}, err
+			}
+			opts.password = pwd
+		}
+	}
+	if rootPath == "" {
+		rootPath = cfg.Root
+	}
+	sizeText := *maxUpload
+	if sizeText == "" {
+		sizeText = cfg.MaxUploadSize
+	}
+	size, err := parseSize(sizeText)
+	if err != nil {
+		return options{}, fmt.Errorf("max upload size: %v", err)
+	}
+	opts.maxUploadSize = size
+	policyText := *onConflict
+	if policyText == "" {
+		policyText = cfg.OnConflict
+	}
+	policy, err := parseConflictPolicy(policyText)
+	if err != nil {
+		return options{}, err
+	}
+	opts.onConflict = policy
+	opts.zipWorkers = cfg.ZipWorkers
+	if *zipWorkersText != "" {
+		n, err := strconv.Atoi(*zipWorkersText)
+		if err != nil || n < 0 {
+			return options{}, fmt.Errorf("invalid zip workers %q: must be a number >= 0", *zipWorkersText)
+		}
+		opts.zipWorkers = n
+	}
+	opts.trashDays = defaultTrashDays
+	if cfg.TrashDays != nil {
+		opts.trashDays = *cfg.TrashDays
+	}
+	if *trashDaysText != "" {
+		n, err := strconv.Atoi(*trashDaysText)
+		if err != nil || n < 0 {
+			return options{}, fmt.Errorf("invalid trash days %q: must be a number >= 0", *trashDaysText)
+		}
+		opts.trashDays = n
+	}
+
+	if opts.port == "" || opts.password == "" {
+		if stdinIsTerminal() {
+			reader := bufio.NewReader(os.Stdin)
+			save := cfg
+			if opts.port == "" {
+				opts.port = choosePort(reader)
+				save.Port, _ = strconv.Atoi(opts.port)
+			}
+			if opts.password == "" {
+				opts.password = choosePassword(reader)
+				save.Password = opts.password
+			}
+			if *configPath != "" && askYesNo(reader, "是否把这些设置保存到 "+*configPath+"? (y/N): ") {
+				if err := writeConfigFile(*configPath, save); err != nil {
+					fmt.Println("保存配置失败:", err)
+				} else {
+					fmt.Println("已保存，下次启动不再询问。")
+				}
+			}
+		}
+		if opts.port == "" {
+			opts.port = defaultPort
+		}
+		// 没有终端可问时不悄悄用谁都知道的 0000
+		if opts.password == "" {
+			return options{}, errors.New("no password configured and stdin is not a terminal: set --password, --password-file, FILETRANSFER_PASSWORD or password in the config file")
+		}
+	}
+	if err := validatePort(opts.port); err != nil {
+		return options{}, err
+	}
+
+	// 没配置任何共享时才用默认的 桌面/Myfiles
+	shareList := flagShares
+	if len(shareList) == 0 {
+		shareList = cfg.Shares
+	}
+	if rootPath == "" && len(shareList) == 0 {
+		base, err := defaultRootBase()
+		if err != nil {
+			return options{}, err
+		}
+		rootPath = filepath.Join(base, "Myfiles")
+	}
+	if rootPath != "" {
+		rootShare := share{Name: defaultShareName(rootPath), Path: rootPath}
+		shareList = append([]share{rootShare}, shareList...)
+	}
+	list, err := normalizeShares(shareList)
+	if err != nil {
+		return options{}, err
+	}
+	opts.shares = list
+	return opts, nil
+}
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// 复制时每次拷这么多就更新一次进度、看一眼请求是不是已经取消
+const copyChunk = 8 << 20
+
+var errSpecialFile = errors.New("cannot copy special file")
+
+// 复制进度，nil 表示不需要
+type copyProgress struct {
+	total  int64 // 开始前算好的总字节数
+	copied atomic.Int64
+	files  atomic.Int64
+}
+
+func (p *copyProgress) add(n int64) {
+	if p != nil {
+		p.copied.Add(n)
+	}
+}
+
+func (p *copyProgress) fileDone() {
+	if p != nil {
+		p.files.Add(1)
+	}
+}
+
+// 管道、socket、设备文件，没法复制
+func isSpecialFile(mode fs.FileMode) bool {
+	return !mode.IsRegular() && !mode.IsDir() && mode&fs.ModeSymlink == 0
+}
+
+// 复制文件 / 文件夹，保留权限和修改时间，软链接原样复制（不跟进去）。
+// 文件夹里的特殊文件跳过，源路径记在 skipped 里，不影响其他的。
+// dst 不能已经存在；出错时不清理，调用方先复制到临时名字再挪到位
+func copyTree(ctx context.Context, src, dst string, prog *copyProgress, skipped *[]string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	st, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	switch {
+	case st.Mode().IsRegular():
+		return copyFile(ctx, src, dst, st, prog)
+	case st.Mode()&fs.ModeSymlink != 0:
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(target, dst)
+	case isSpecialFile(st.Mode()):
+		*skipped = append(*skipped, src)
+		fmt.Printf("复制时跳过: %s (not a regular file)\n", src)
+		return nil
+	}
+	if err := os.Mkdir(dst, st.Mode().Perm()|0700); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if isInternalName(e.Name()) {
+			continue
+		}
+		if err := copyTree(ctx, filepath.Join(src, e.Name()), filepath.Join(dst, e.Name()), prog, skipped); err != nil {
+			return err
+		}
+	}
+	// 里面的东西写完了再设，不然又被改成现在的时间
+	_ = os.Chmod(dst, st.Mode().Perm())
+	return os.Chtimes(dst, st.ModTime(), st.ModTime())
+}
+
+// 文件系统支持的话（btrfs、XFS 等）用 reflink 共享数据块，瞬间完成；
+// 否则分块拷贝，Linux 上 io.Copy 在两个文件之间会走 copy_file_range，数据不经过用户态
+func copyFile(ctx context.Context, src, dst string, st fs.FileInfo, prog *copyProgress) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, st.Mode().Perm()|0600)
+	if err != nil {
+		return err
+	}
+	if cloneFile(out, in) {
+		prog.add(st.Size())
+	} else {
+		for {
+			var n int64
+			n, err = io.CopyN(out, in, copyChunk)
+			prog.add(n)
+			if err == io.EOF {
+				err = nil
+				break
+			}
+			if err == nil {
+				err = ctx.Err()
+			}
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	prog.fileDone()
+	_ = os.Chmod(dst, st.Mode().Perm())
+	return os.Chtimes(dst, st.ModTime(), st.ModTime())
+}
+
+// 先完整复制到目标文件夹里的隐藏临时名字，再按冲突策略改成最终名字；
+// 中途失败或者请求取消了就删掉临时的，目标文件夹里不会出现复制了一半的东西。
+// 返回的 skipped 是文件夹里跳过的特殊文件；src 本身是特殊文件时返回 errSpecialFile
+func copyIntoPlace(ctx context.Context, src, dst string, policy conflictPolicy, prog *copyProgress) (finalPath, outcome string, skipped []string, err error) {
+	st, err := os.Lstat(src)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if isSpecialFile(st.Mode()) {
+		return "", "", nil, fmt.Errorf("%s: %w", filepath.Base(src), errSpecialFile)
+	}
+	if policy == conflictSkip || policy == conflictFail {
+		if _, err := os.Lstat(dst); err == nil {
+			if policy == conflictSkip {
+				return "", "", nil, errSkippedFile
+			}
+			return "", "", nil, errFileExists
+		}
+	}
+	tmp, err := tempSibling(dst)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if err := copyTree(ctx, src, tmp, prog, &skipped); err != nil {
+		_ = os.RemoveAll(tmp)
+		return "", "", nil, err
+	}
+	if finalPath, outcome, err = moveIntoPlace(tmp, dst, policy); err != nil {
+		_ = os.RemoveAll(tmp)
+		return "", "", nil, err
+	}
+	return finalPath, outcome, skipped, nil
+}
+
+// 跳过的特殊文件每个一条 skipped 结果，名字相对源共享，和请求里的路径对得上
+func skippedResults(item transferItem, skipped []string, msg string) []fileResult {
+	var out []fileResult
+	for _, p := range skipped {
+		name := item.rel
+		if rel, err := filepath.Rel(item.src, p); err == nil {
+			name = path.Join(filepath.ToSlash(item.rel), filepath.ToSlash(rel))
+		}
+		out = append(out, fileResult{Name: name, Status: resultSkipped, Code: codeUnsupported, Error: msg})
+	}
+	return out
+}
+
+// 进度行和最后的结果行，?progress=1 时一行一个 JSON（application/x-ndjson）
+type copyProgressLine struct {
+	Type   string `json:"type"` // progress
+	Copied int64  `json:"copied"`
+	Total  int64  `json:"total"`
+	Files  int64  `json:"files"`
+}
+
+type copyResultLine struct {
+	Type   string `json:"type"` // result
+	Status int    `json:"status"`
+	*moveResponse
+}
+
+// /api/copy：在服务端复制文件 / 文件夹，请求和返回同 /api/move（源共享可以是只读的）。
+// 大的复制可以带 ?progress=1，边复制边每半秒返回一行进度，最后一行是结果
+func handleCopy(w http.ResponseWriter, r *http.Request) {
+	if !isAuthed(r) {
+		writeAPIError(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req moveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, r, codeBadRequest, "bad json")
+		return
+	}
+	resp, policy, ok := startTransfer(w, r, req, false)
+	if !ok {
+		return
+	}
+	var items []transferItem
+	var results [][]fileResult // 和 req.Paths 一一对应，Status 为空的等复制完再填
+	prog := &copyProgress{}
+	for _, rel := range req.Paths {
+		item, res := resolveTransfer(req, rel)
+		if res.Status == "" {
+			prog.total += treeSize(item.src)
+		}
+		items = append(items, item)
+		results = append(results, []fileResult{res})
+	}
+	run := func() {
+		for i, item := range items {
+			if results[i][0].Status == "" {
+				results[i] = copyItem(r.Context(), item, policy, prog)
+			}
+			for _, res := range results[i] {
+				resp.add(res)
+			}
+		}
+	}
+
+	if !parseBoolParam(r.URL.Query().Get("progress")) {
+		run()
+		writeJSON(w, resp.status(), resp)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	report := func() {
+		_ = enc.Encode(copyProgressLine{Type: "progress", Copied: prog.copied.Load(), Total: prog.total, Files: prog.files.Load()})
+		_ = rc.Flush()
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		run()
+	}()
+	tick := time.NewTicker(500 * time.Millisecond)
+	defer tick.Stop()
+	report()
+	for waiting := true; waiting; {
+		select {
+		case <-tick.C:
+			report()
+		case <-done:
+			waiting = false
+		}
+	}
+	report()
+	_ = enc.Encode(copyResultLine{Type: "result", Status: resp.status(), moveResponse: resp})
+}
+
+// 第一条是这一项本身的结果，后面是文件夹里跳过的特殊文件
+func copyItem(ctx context.Context, item transferItem, policy conflictPolicy, prog *copyProgress) []fileResult {
+	res := fileResult{Name: item.rel, Status: resultFailed}
+	if !item.info.IsDir() {
+		res.Size = item.info.Size()
+	}
+	finalPath, outcome, skipped, err := copyIntoPlace(ctx, item.src, item.dst, policy, prog)
+	switch {
+	case errors.Is(err, errSkippedFile):
+		res.Status, res.Code, res.Error = resultSkipped, codeExists, "already exists"
+		return []fileResult{res}
+	case err != nil:
+		res.Code, res.Error = uploadErrorCode(err), err.Error()
+		if errors.Is(err, errSpecialFile) {
+			res.Code = codeUnsupported
+		}
+		return []fileResult{res}
+	}
+	res.Status, res.Outcome, res.Path = resultOK, outcome, relTo(item.dsh, finalPath)
+	fmt.Printf("已复制: %s -> %s\n", item.src, finalPath)
+	return append([]fileResult{res}, skippedResults(item, skipped, "not a regular file, not copied")...)
+}
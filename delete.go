@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+type deleteRequest struct {
+	Share     string   `json:"share"`
+	Paths     []string `json:"paths"`     // 相对共享根目录
+	Recursive bool     `json:"recursive"` // 不为 true 时只删文件和空文件夹
+	Permanent bool     `json:"permanent"` // 不进回收站，直接删
+}
+
+type deleteResponse struct {
+	Share string `json:"share"`
+	resultList
+}
+
+// /api/delete：删除一个或多个文件 / 文件夹，默认挪进回收站（trash.go）。非空文件夹要带 recursive，
+// 否则返回 not_empty，页面据此再确认一次。共享根目录本身不能删
+func handleDelete(w http.ResponseWriter, r *http.Request) {
+	if !isAuthed(r) {
+		writeAPIError(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req deleteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, r, codeBadRequest, "bad json")
+		return
+	}
+	if len(req.Paths) == 0 {
+		writeAPIError(w, r, codeBadRequest, "no paths")
+		return
+	}
+	sh, err := findShare(req.Share)
+	if err != nil {
+		writeAPIError(w, r, codeNotFound, err.Error())
+		return
+	}
+	if sh.ReadOnly {
+		writeAPIError(w, r, codeReadOnly, "share "+sh.Name+" is read-only")
+		return
+	}
+	resp := &deleteResponse{Share: sh.Name, resultList: resultList{Files: []fileResult{}}}
+	for _, rel := range req.Paths {
+		resp.add(deletePath(sh, rel, req.Recursive, req.Permanent || !trashEnabled(), r))
+	}
+	writeJSON(w, resp.status(), resp)
+}
+
+func deletePath(sh *share, rel string, recursive, permanent bool, r *http.Request) fileResult {
+	res := fileResult{Name: rel, Status: resultFailed}
+	full, err := joinSafe(sh.Path, rel)
+	if err != nil {
+		res.Code, res.Error = codeInvalidName, "invalid path"
+		return res
+	}
+	relPath, err := filepath.Rel(sh.Path, full)
+	if err != nil || relPath == "." {
+		res.Code, res.Error = codeInvalidName, "cannot delete the share root"
+		return res
+	}
+	res.Path = filepath.ToSlash(relPath)
+	st, err := os.Lstat(full)
+	if errors.Is(err, fs.ErrNotExist) {
+		res.Code, res.Error = codeNotFound, "not found"
+		return res
+	}
+	if err != nil {
+		res.Code, res.Error = codeIO, err.Error()
+		return res
+	}
+	if st.IsDir() && !recursive && !dirIsEmpty(full) {
+		res.Code, res.Error = codeNotEmpty, "folder is not empty"
+		return res
+	}
+	if permanent {
+		err = os.RemoveAll(full)
+		res.Outcome = outcomeDeleted
+	} else {
+		err = moveToTrash(sh, full, relPath, r)
+		res.Outcome = outcomeTrashed
+	}
+	if err != nil {
+		res.Code, res.Error = codeIO, err.Error()
+		return res
+	}
+	if permanent {
+		fmt.Printf("已删除: %s (%s)\n", full, requestClient(r))
+	} else {
+		fmt.Printf("已移到回收站: %s (%s)\n", full, requestClient(r))
+	}
+	res.Status = resultOK
+	if !st.IsDir() {
+		res.Size = st.Size()
+	}
+	return res
+}
+
+func dirIsEmpty(dir string) bool {
+	f, err := os.Open(dir)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	names, _ := f.Readdirnames(1)
+	return len(names) == 0
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/flate"
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// /api/extract：把共享里的 ZIP / tar / tar.gz / tar.zst / tar.bz2 解压到共享里的文件夹。
+// 每个文件和上传一样先写临时文件，再按冲突策略挪到位，返回的结果也和 /upload 一样（见 response.go）。
+// 包里的路径都要过 cleanUploadPath + joinSafe，软链接、硬链接、设备文件一律跳过，防 zip slip；
+// 文件数和解出来的总大小有上限，按实际解出来的字节算，不信包里写的大小，防 zip 炸弹
+
+const (
+	extractMaxFiles     = 100000
+	extractMaxSize      = 64 << 30 // 不管包多大，最多解出这么多
+	extractMaxRatio     = 200      // 最多解出包大小的多少倍，正常的包很少超过 20 倍
+	extractMinAllowance = 1 << 30  // 小包至少允许解出 1GB
+)
+
+var errExtractLimit = errors.New("archive expands beyond the extraction limit")
+
+type extractRequest struct {
+	Share    string `json:"share"`
+	Archive  string `json:"archive"`  // 相对共享根目录
+	Target   string `json:"target"`   // 解压到哪，空 = 包旁边以包名命名的文件夹
+	Conflict string `json:"conflict"` // 同 /upload，空 = 服务端默认
+	Encoding string `json:"encoding"` // 文件名编码：auto（默认）、utf-8、gbk
+}
+
+// 包里的一项，zip 和 tar 都转成这个
+type extractEntry struct {
+	name    string // 已经转成 UTF-8 的路径
+	mode    fs.FileMode
+	modTime time.Time
+	open    func() (io.ReadCloser, error)
+}
+
+type extractor struct {
+	sh        *share
+	fullDir   string
+	targetRel string
+	policy    conflictPolicy
+	resp      *uploadResponse
+	files     int
+	left      int64 // 还能解出多少字节
+}
+
+func handleExtract(w http.ResponseWriter, r *http.Request) {
+	if !isAuthed(r) {
+		writeAPIError(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req extractRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, r, codeBadRequest, "bad json")
+		return
+	}
+	policy, err := parseConflictPolicy(req.Conflict)
+	if err != nil {
+		writeAPIError(w, r, codeBadRequest, err.Error())
+		return
+	}
+	enc := strings.ToLower(strings.TrimSpace(req.Encoding))
+	switch enc {
+	case "", "auto":
+		enc = "auto"
+	case "utf8", "utf-8":
+		enc = "utf-8"
+	case "gbk", "gb18030", "cp936":
+		enc = "gbk"
+	default:
+		writeAPIError(w, r, codeBadRequest, "invalid encoding (auto, utf-8 or gbk)")
+		return
+	}
+	sh, err := findShare(req.Share)
+	if err != nil {
+		writeAPIError(w, r, codeNotFound, err.Error())
+		return
+	}
+	if sh.ReadOnly {
+		writeAPIError(w, r, codeReadOnly, "share "+sh.Name+" is read-only")
+		return
+	}
+	archiveRel := strings.TrimSpace(req.Archive)
+	archiveFull, err := joinSafe(sh.Path, archiveRel)
+	if err != nil || archiveFull == sh.Path {
+		writeAPIError(w, r, codeInvalidName, "invalid archive path")
+		return
+	}
+	st, err := os.Stat(archiveFull)
+	if err != nil || st.IsDir() {
+		writeAPIError(w, r, codeNotFound, "archive not found")
+		return
+	}
+	targetRel := strings.TrimSpace(req.Target)
+	if targetRel == "" {
+		stem, _ := splitExt(filepath.Base(archiveFull))
+		targetRel = path.Join(path.Dir(filepath.ToSlash(archiveRel)), stem)
+	}
+	fullDir, err := joinSafe(sh.Path, targetRel)
+	if err != nil {
+		writeAPIError(w, r, codeInvalidName, "invalid target dir")
+		return
+	}
+
+	f, err := os.Open(archiveFull)
+	if err != nil {
+		writeAPIError(w, r, codeIO, err.Error())
+		return
+	}
+	defer f.Close()
+	x := &extractor{
+		sh:        sh,
+		fullDir:   fullDir,
+		targetRel: filepath.ToSlash(targetRel),
+		policy:    policy,
+		resp:      &uploadResponse{Share: sh.Name, Target: filepath.ToSlash(targetRel), resultList: resultList{Files: []fileResult{}}, fullDir: fullDir},
+		left:      extractAllowance(st.Size()),
+	}
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(f, head)
+	head = head[:n]
+	if bytes.HasPrefix(head, []byte("PK\x03\x04")) || bytes.HasPrefix(head, []byte("PK\x05\x06")) {
+		zr, err := zip.NewReader(f, st.Size())
+		if err != nil {
+			writeAPIError(w, r, codeBadRequest, "bad zip: "+err.Error())
+			return
+		}
+		if err := x.checkZip(zr); err != nil {
+			writeAPIError(w, r, codeTooLarge, err.Error())
+			return
+		}
+		if err := os.MkdirAll(fullDir, 0755); err != nil {
+			writeAPIError(w, r, codeIO, "failed to ensure target dir: "+err.Error())
+			return
+		}
+		err = walkZip(zr, enc, x.extract)
+		x.finish(err)
+		writeUploadResponse(w, r, x.resp)
+		return
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		writeAPIError(w, r, codeIO, err.Error())
+		return
+	}
+	src, err := tarStream(bufio.NewReader(f), head)
+	if err != nil {
+		writeAPIError(w, r, codeBadRequest, err.Error())
+		return
+	}
+	defer src.Close()
+	if err := os.MkdirAll(fullDir, 0755); err != nil {
+		writeAPIError(w, r, codeIO, "failed to ensure target dir: "+err.Error())
+		return
+	}
+	err = walkTar(tar.NewReader(src), enc, x.extract)
+	x.finish(err)
+	writeUploadResponse(w, r, x.resp)
+}
+
+func extractAllowance(archiveSize int64) int64 {
+	if archiveSize > extractMaxSize/extractMaxRatio {
+		return extractMaxSize
+	}
+	return max(archiveSize*extractMaxRatio, extractMinAllowance)
+}
+
+// 按文件头认格式：gzip / zstd / bzip2 压过的 tar，或者不压缩的 tar
+func tarStream(br *bufio.Reader, head []byte) (io.ReadCloser, error) {
+	switch {
+	case bytes.HasPrefix(head, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		zr, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case bytes.HasPrefix(head, []byte("BZh")):
+		return io.NopCloser(bzip2.NewReader(br)), nil
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		return io.NopCloser(br), nil
+	}
+	return nil, fmt.Errorf("unsupported archive format (zip, tar, tar.gz, tar.zst or tar.bz2)")
+}
+
+// ZIP 的目录在文件尾，先按包里写的文件数和大小检查一遍，明显超限的一个文件都不解
+func (x *extractor) checkZip(zr *zip.Reader) error {
+	if len(zr.File) > extractMaxFiles {
+		return fmt.Errorf("archive has %d entries (limit %d)", len(zr.File), extractMaxFiles)
+	}
+	var total uint64
+	for _, f := range zr.File {
+		total += f.UncompressedSize64
+		if total > uint64(x.left) {
+			return fmt.Errorf("archive expands to more than %d bytes", x.left)
+		}
+	}
+	return nil
+}
+
+func walkZip(zr *zip.Reader, enc string, fn func(extractEntry) error) error {
+	zr.RegisterDecompressor(zip.Deflate, flate.NewReader)
+	for _, f := range zr.File {
+		if err := fn(extractEntry{
+			name:    zipEntryName(f, enc),
+			mode:    f.Mode(),
+			modTime: f.Modified,
+			open:    f.Open,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkTar(tr *tar.Reader, enc string, fn func(extractEntry) error) error {
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			continue
+		}
+		// 只认普通文件和文件夹。硬链接的 FileInfo 看着像普通文件，但包里没有内容，
+		// 照着解会得到一个空文件，所以其他类型都标成 irregular，交给 extract 跳过
+		mode := hdr.FileInfo().Mode()
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeDir {
+			mode = fs.ModeIrregular | mode.Perm()
+		}
+		if err := fn(extractEntry{
+			name:    decodeArchiveName(hdr.Name, false, enc),
+			mode:    mode,
+			modTime: hdr.ModTime,
+			open:    func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		}); err != nil {
+			return err
+		}
+	}
+}
+
+// 中文 Windows 自带的压缩和很多老软件用 GBK 存文件名，也不设 UTF-8 标志。
+// 有 Info-ZIP 的 Unicode Path 扩展字段（0x7075）就用它，否则交给 decodeArchiveName 猜
+func zipEntryName(f *zip.File, enc string) string {
+	extra := f.Extra
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		if 4+size > len(extra) {
+			break
+		}
+		data := extra[4 : 4+size]
+		if tag == 0x7075 && size > 5 && data[0] == 1 &&
+			binary.LittleEndian.Uint32(data[1:]) == crc32.ChecksumIEEE([]byte(f.Name)) && utf8.Valid(data[5:]) {
+			return string(data[5:])
+		}
+		extra = extra[4+size:]
+	}
+	return decodeArchiveName(f.Name, f.Flags&zipFlagUTF8 != 0, enc)
+}
+
+// auto：标了 UTF-8 或者本身就是合法 UTF-8 的原样用，否则按 GBK 解；
+// 解不出来的字节换成 "_"，至少不会生成乱码文件名
+func decodeArchiveName(raw string, flagUTF8 bool, enc string) string {
+	if flagUTF8 || enc == "utf-8" || (enc == "auto" && utf8.ValidString(raw)) {
+		return strings.ToValidUTF8(raw, "_")
+	}
+	if s, err := simplifiedchinese.GB18030.NewDecoder().String(raw); err == nil && !strings.ContainsRune(s, utf8.RuneError) {
+		return s
+	}
+	return strings.ToValidUTF8(raw, "_")
+}
+
+// 解出一项，结果记进 resp；返回错误表示整个包不用再解了
+func (x *extractor) extract(e extractEntry) error {
+	x.files++
+	if x.files > extractMaxFiles {
+		return errExtractLimit
+	}
+	res := fileResult{Name: e.name, Status: resultFailed}
+	rel, err := cleanUploadPath(e.name)
+	if err == nil {
+		_, err = joinSafe(x.sh.Path, path.Join(x.targetRel, rel))
+	}
+	if err != nil {
+		res.Code, res.Error = codeInvalidName, "unsafe path in archive"
+		x.resp.add(res)
+		return nil
+	}
+	dst := filepath.Join(x.fullDir, filepath.FromSlash(rel))
+	if e.mode.IsDir() {
+		if err := os.MkdirAll(dst, 0755); err != nil {
+			res.Code, res.Error = codeIO, err.Error()
+			x.resp.add(res)
+		}
+		return nil
+	}
+	if !e.mode.IsRegular() {
+		res.Status, res.Code, res.Error = resultSkipped, codeUnsupported, "links and special files are not extracted"
+		x.resp.add(res)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		res.Code, res.Error = codeIO, err.Error()
+		x.resp.add(res)
+		return nil
+	}
+	rc, err := e.open()
+	if err != nil {
+		res.Code, res.Error = codeIO, err.Error()
+		x.resp.add(res)
+		return nil
+	}
+	up, err := receiveUploadFile(&capReader{r: rc, left: &x.left}, dst, x.policy, "")
+	_ = rc.Close()
+	res.Size, res.SHA256 = up.Size, up.SHA256
+	switch {
+	case errors.Is(err, errSkippedFile):
+		res.Status, res.Code, res.Error = resultSkipped, codeExists, "already exists"
+	case errors.Is(err, errExtractLimit):
+		res.Code, res.Error = codeTooLarge, err.Error()
+		x.resp.add(res)
+		return err
+	case err != nil:
+		res.Code, res.Error = uploadErrorCode(err), err.Error()
+	default:
+		// 不给组和其他人写权限，自己总能读写；可执行位保留
+		perm := e.mode.Perm()
+		if perm == 0 {
+			perm = 0644
+		}
+		_ = os.Chmod(up.Path, perm&^0022|0600)
+		if !e.modTime.IsZero() {
+			_ = os.Chtimes(up.Path, e.modTime, e.modTime)
+		}
+		res.Status, res.Outcome, res.full = resultOK, up.Outcome, up.Path
+		if relPath, err := filepath.Rel(x.sh.Path, up.Path); err == nil {
+			res.Path = filepath.ToSlash(relPath)
+		}
+	}
+	x.resp.add(res)
+	return nil
+}
+
+// 解到一半停下来的原因记在 Errors 里，已经解出来的文件保留
+func (x *extractor) finish(err error) {
+	switch {
+	case err == nil:
+	case errors.Is(err, errExtractLimit):
+		msg := "stopped: archive expands beyond the size limit, possibly a zip bomb"
+		if x.files > extractMaxFiles {
+			msg = fmt.Sprintf("stopped: archive has more than %d entries", extractMaxFiles)
+		}
+		x.resp.Errors = append(x.resp.Errors, apiError{Code: codeTooLarge, Error: msg})
+	default:
+		x.resp.Errors = append(x.resp.Errors, apiError{Code: codeBadRequest, Error: "bad archive: " + err.Error()})
+	}
+}
+
+// 所有文件共用一个剩余额度，超了返回 errExtractLimit，receiveUploadFile 会删掉临时文件
+type capReader struct {
+	r    io.Reader
+	left *int64
+}
+
+func (c *capReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > *c.left+1 {
+		p = p[:*c.left+1]
+	}
+	n, err := c.r.Read(p)
+	*c.left -= int64(n)
+	if *c.left < 0 {
+		return n, errExtractLimit
+	}
+	return n, err
+}
@@ -1,3 +1,12 @@
 module github.com/lzaeh/FileTransfer.git
 
 go 1.25.4
+
+require github.com/klauspost/compress v1.18.0
+
+require (
+	golang.org/x/term v0.37.0
+	golang.org/x/text v0.31.0
+)
+
+require golang.org/x/sys v0.38.0 // indirect
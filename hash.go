@@ -0,0 +1,108 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+type hashResponse struct {
+	File      string `json:"file"`
+	Algorithm string `json:"algorithm"`
+	Hash      string `json:"hash"`
+	Size      int64  `json:"size"`
+}
+
+func newHasher(algo string) (hash.Hash, error) {
+	switch algo {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported algorithm %q (md5, sha1 or sha256)", algo)
+}
+
+// 客户端给的期望 SHA-256，统一成小写十六进制；空表示不校验
+func normalizeSHA256(s string) (string, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return "", nil
+	}
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 %q", s)
+	}
+	return s, nil
+}
+
+func hashFile(path, algo string) (string, int64, error) {
+	h, err := newHasher(algo)
+	if err != nil {
+		return "", 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// /api/hash?file=&algo=：算服务端文件的摘要，下载后可以对照
+func handleHash(w http.ResponseWriter, r *http.Request) {
+	if !isAuthed(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	sh, ok := shareFromRequest(w, r)
+	if !ok {
+		return
+	}
+	rel := strings.TrimSpace(r.URL.Query().Get("file"))
+	full, err := joinSafe(sh.Path, rel)
+	if err != nil {
+		http.Error(w, "invalid file", http.StatusBadRequest)
+		return
+	}
+	algo := strings.ToLower(strings.TrimSpace(r.URL.Query().Get("algo")))
+	if algo == "" {
+		algo = "sha256"
+	}
+	if _, err := newHasher(algo); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	st, err := os.Stat(full)
+	if err != nil {
+		http.Error(w, "file not found", http.StatusNotFound)
+		return
+	}
+	if st.IsDir() {
+		http.Error(w, "cannot hash a directory", http.StatusBadRequest)
+		return
+	}
+	sum, size, err := hashFile(full, algo)
+	if err != nil {
+		http.Error(w, "hash failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(hashResponse{File: rel, Algorithm: algo, Hash: sum, Size: size})
+}
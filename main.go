@@ -1,14 +1,14 @@
 package main
 
 import (
-	"archive/zip"
 	"bufio"
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html"
-	"io"
 	"io/fs"
 	"net/http"
 	"os"
@@ -17,11 +17,6 @@ import (
 	"time"
 )
 
-func getDesktop() string {
-	home, _ := os.UserHomeDir()
-	return filepath.Join(home, "Desktop")
-}
-
 var (
 	authPassword string
 	serverToken  string
@@ -41,7 +36,6 @@ func generateServerToken() string {
 
 // 选择端口：提示默认端口，问是否修改
 func choosePort(reader *bufio.Reader) string {
-	defaultPort := "8080"
 	fmt.Printf("默认端口为: %s\n", defaultPort)
 	fmt.Print("是否要修改端口? (y/N): ")
 	line, _ := reader.ReadString('\n')
@@ -51,10 +45,15 @@ func choosePort(reader *bufio.Reader) string {
 			fmt.Print("请输入新端口(例如 8080): ")
 			p, _ := reader.ReadString('\n')
 			p = strings.TrimSpace(p)
-			if p != "" {
-				return p
+			if p == "" {
+				fmt.Println("端口不能为空。")
+				continue
 			}
-			fmt.Println("端口不能为空。")
+			if err := validatePort(p); err != nil {
+				fmt.Println("端口无效，请输入 1-65535 之间的数字。")
+				continue
+			}
+			return p
 		}
 	}
 	return defaultPort
@@ -62,8 +61,7 @@ func choosePort(reader *bufio.Reader) string {
 
 // 选择密码：提示默认密码 0000，问是否修改
 func choosePassword(reader *bufio.Reader) string {
-	defaultPwd := "0000"
-	fmt.Printf("默认密码为: %s\n", defaultPwd)
+	fmt.Printf("默认密码为: %s\n", defaultPassword)
 	fmt.Print("是否要修改密码? (y/N): ")
 	line, _ := reader.ReadString('\n')
 	line = strings.TrimSpace(line)
@@ -78,7 +76,7 @@ func choosePassword(reader *bufio.Reader) string {
 			fmt.Println("密码不能为空。")
 		}
 	}
-	return defaultPwd
+	return defaultPassword
 }
 
 func isAuthed(r *http.Request) bool {
@@ -318,7 +316,7 @@ const pageTemplate = "" +
 	"              <div class=\"title-text-main\">File Transfer</div>\n" +
 	"              <div class=\"title-text-sub\">用chatGPT弄出来的简单局域网传输工具。</div>\n" +
 	"              <div class=\"chip-row\">\n" +
-	"                <div class=\"chip\">Root: Myfiles</div>\n" +
+	"                <div class=\"chip\">Named shares</div>\n" +
 	"                <div class=\"chip\">Browser upload</div>\n" +
 	"                <div class=\"chip\">ZIP download</div>\n" +
 	"              </div>\n" +
@@ -332,15 +330,17 @@ const pageTemplate = "" +
 	"    </div>\n" +
 	"\n" +
 	"    <div class=\"root-card\">\n" +
-	"        <div class=\"root-label\">Root directory on your PC:</div>\n" +
+	"        <div class=\"root-label\">Shared folders on your PC:</div>\n" +
 	"        <div class=\"root-path\">__ROOT__</div>\n" +
 	"    </div>\n" +
 	"\n" +
 	"    <div class=\"hint-card\">\n" +
 	"      点 <b>Manage</b> 打开文件浏览器：\n" +
 	"      <ul style=\"margin:8px 0 0 18px; padding:0;\">\n" +
-	"        <li>点击文件 = 下载；双击文件夹 = 进入；绿色按钮 = 打包当前文件夹 ZIP 下载。</li>\n" +
-	"        <li>New(+) = 在当前目录新建文件夹/文件；Upload(⇪) = 上传文件到当前目录。</li>\n" +
+	"        <li>点击文件 = 下载；双击文件夹 = 进入；绿色按钮 = 打包当前文件夹下载（旁边可以选 ZIP / TAR / TAR.GZ / TAR.ZST）；勾选几项后点 Download selected = 只打包勾选的。</li>\n" +
+	"        <li>New(+) = 在当前目录新建文件夹/文件；Upload(⇪) = 上传文件到当前目录；Upload folder = 按目录结构上传整个文件夹，也可以直接拖进来。</li>\n" +
+	"        <li>Delete = 删除勾选的（没勾选就删选中的），先进回收站，点 Trash 可以恢复；Extract = 在服务端解压选中的 ZIP / tar 包；Rename / Move to / Copy to = 改名、挪到或复制到别的文件夹（复制在服务端完成，不经过浏览器）。</li>\n" +
+	"        <li>上面的搜索框 = 在当前文件夹（含子文件夹）里按名字找，不区分大小写，可以用 *.jpg 这样的通配符；选 Contents 改成找文本文件里的内容（UTF-8 和 GBK 都行）。</li>\n" +
 	"      </ul>\n" +
 	"    </div>\n" +
 	"  </div>\n" +
@@ -350,29 +350,86 @@ const pageTemplate = "" +
 	"      <div style=\"display:flex; justify-content:space-between; align-items:center; margin-bottom:8px; gap:8px; flex-wrap:wrap;\">\n" +
 	"        <div>\n" +
 	"          <div style=\"font-weight:600;\">File Browser</div>\n" +
+	"          <select id=\"fsShareSelect\" title=\"Share\" style=\"display:none; margin:4px 0; padding:2px 6px; border-radius:6px; border:1px solid #d1d5db; font-size:12px;\"></select>\n" +
 	"          <div id=\"fsPath\" style=\"font-size:12px; color:#6b7280; word-break:break-all;\"></div>\n" +
 	"        </div>\n" +
 	"        <div style=\"display:flex; gap:8px; flex-wrap:wrap; align-items:center;\">\n" +
 	"          <button id=\"fsNewBtn\" title=\"New folder or file\" style=\"padding:6px 10px; border-radius:999px; border:none; background:#4f46e5; color:white; font-size:12px; cursor:pointer;\">New +</button>\n" +
 	"          <button id=\"fsUploadBtn\" title=\"Upload to this folder\" style=\"padding:6px 10px; border-radius:999px; border:none; background:#0ea5e9; color:white; font-size:12px; cursor:pointer;\">Upload ⇪</button>\n" +
 	"          <input id=\"fsUploadInput\" type=\"file\" multiple style=\"display:none;\" />\n" +
+	"          <button id=\"fsUploadDirBtn\" title=\"Upload a whole folder, keeping its structure\" style=\"padding:6px 10px; border-radius:999px; border:none; background:#0284c7; color:white; font-size:12px; cursor:pointer;\">Upload folder ⇪</button>\n" +
+	"          <input id=\"fsUploadDirInput\" type=\"file\" webkitdirectory directory multiple style=\"display:none;\" />\n" +
+	"          <button id=\"fsHashBtn\" title=\"Show the SHA-256 of the selected file\" style=\"padding:6px 10px; border-radius:999px; border:none; background:#e5e7eb; color:#111827; font-size:12px; cursor:pointer;\">Checksum</button>\n" +
+	"          <button id=\"fsExtractBtn\" title=\"Extract the selected ZIP / tar archive into a folder\" style=\"padding:6px 10px; border-radius:999px; border:none; background:#e5e7eb; color:#111827; font-size:12px; cursor:pointer;\">Extract</button>\n" +
+	"          <button id=\"fsRenameBtn\" title=\"Rename the selected item\" style=\"padding:6px 10px; border-radius:999px; border:none; background:#e5e7eb; color:#111827; font-size:12px; cursor:pointer;\">Rename</button>\n" +
+	"          <button id=\"fsMoveBtn\" title=\"Move the checked items, or the selected one, to another folder\" style=\"padding:6px 10px; border-radius:999px; border:none; background:#e5e7eb; color:#111827; font-size:12px; cursor:pointer;\">Move to</button>\n" +
+	"          <button id=\"fsCopyBtn\" title=\"Copy the checked items, or the selected one, to another folder on the server\" style=\"padding:6px 10px; border-radius:999px; border:none; background:#e5e7eb; color:#111827; font-size:12px; cursor:pointer;\">Copy to</button>\n" +
+	"          <button id=\"fsDeleteBtn\" title=\"Delete the checked items, or the selected one\" style=\"padding:6px 10px; border-radius:999px; border:none; background:#dc2626; color:white; font-size:12px; cursor:pointer;\">Delete</button>\n" +
+	"          <button id=\"fsTrashBtn\" title=\"Show deleted items, restore or remove them for good\" style=\"padding:6px 10px; border-radius:999px; border:none; background:#e5e7eb; color:#111827; font-size:12px; cursor:pointer;\">Trash</button>\n" +
 	"          <button id=\"fsUpBtn\" style=\"padding:6px 10px; border-radius:999px; border:none; background:#e5e7eb; color:#111827; font-size:12px; cursor:pointer;\">Up</button>\n" +
 	"          <a id=\"fsZipLink\" href=\"#\" style=\"padding:6px 10px; border-radius:999px; background:#16a34a; color:white; font-size:12px; text-decoration:none;\">Download this folder</a>\n" +
+	"          <select id=\"fsFormatSelect\" title=\"Archive format (Safari unpacks ZIP automatically, TAR keeps symlinks)\" style=\"padding:5px 6px; border-radius:999px; border:1px solid #d1d5db; font-size:12px;\">\n" +
+	"            <option value=\"zip\">ZIP</option>\n" +
+	"            <option value=\"tar\">TAR</option>\n" +
+	"            <option value=\"tar.gz\">TAR.GZ</option>\n" +
+	"            <option value=\"tar.zst\">TAR.ZST</option>\n" +
+	"          </select>\n" +
+	"          <button id=\"fsZipSelBtn\" title=\"Download the checked items as one ZIP\" style=\"display:none; padding:6px 10px; border-radius:999px; border:none; background:#15803d; color:white; font-size:12px; cursor:pointer;\">Download selected</button>\n" +
 	"          <button id=\"fsCloseBtn\" style=\"padding:6px 10px; border-radius:999px; border:none; background:#9ca3af; color:white; font-size:12px; cursor:pointer;\">Close</button>\n" +
 	"        </div>\n" +
 	"      </div>\n" +
 	"\n" +
+	"      <div style=\"display:flex; gap:6px; flex-wrap:wrap; align-items:center; margin-bottom:8px;\">\n" +
+	"        <input id=\"fsSearchInput\" type=\"search\" placeholder=\"Search names in this folder, e.g. report or *.jpg\" style=\"flex:1; min-width:180px; padding:5px 10px; border-radius:999px; border:1px solid #d1d5db; font-size:12px;\" />\n" +
+	"        <select id=\"fsSearchMode\" title=\"Search file names, or the text inside files\" style=\"padding:5px 6px; border-radius:999px; border:1px solid #d1d5db; font-size:12px;\">\n" +
+	"          <option value=\"name\">Names</option>\n" +
+	"          <option value=\"content\">Contents</option>\n" +
+	"        </select>\n" +
+	"        <select id=\"fsSearchType\" title=\"What to look for\" style=\"padding:5px 6px; border-radius:999px; border:1px solid #d1d5db; font-size:12px;\">\n" +
+	"          <option value=\"\">Files and folders</option>\n" +
+	"          <option value=\"file\">Files</option>\n" +
+	"          <option value=\"dir\">Folders</option>\n" +
+	"        </select>\n" +
+	"        <button id=\"fsSearchBtn\" style=\"padding:6px 10px; border-radius:999px; border:none; background:#4f46e5; color:white; font-size:12px; cursor:pointer;\">Search</button>\n" +
+	"      </div>\n" +
+	"\n" +
 	"      <div id=\"fsUploadPanel\" style=\"display:none; padding:8px 10px; border-radius:10px; background:#f8fafc; border:1px solid #e5e7eb; margin-bottom:8px;\">\n" +
 	"        <div style=\"display:flex; gap:12px; font-size:12px; color:#374151; flex-wrap:wrap; align-items:center; margin-bottom:6px;\">\n" +
 	"          <span>Progress: <span id=\"fsUploadPercent\">0</span>%</span>\n" +
 	"          <span>Speed: <span id=\"fsUploadSpeed\">0 MB/s</span></span>\n" +
 	"        </div>\n" +
 	"        <progress id=\"fsUploadProg\" value=\"0\" max=\"100\" style=\"width:100%;\"></progress>\n" +
+	"        <div id=\"fsConflictBox\" style=\"display:none; margin-top:6px; padding:8px; border-radius:8px; background:#fff7ed; border:1px solid #fed7aa; font-size:12px; color:#374151;\">\n" +
+	"          <div id=\"fsConflictText\" style=\"margin-bottom:6px; word-break:break-all;\"></div>\n" +
+	"          <div style=\"display:flex; gap:6px; flex-wrap:wrap;\">\n" +
+	"            <button data-policy=\"overwrite\" style=\"padding:4px 10px; border-radius:999px; border:none; background:#dc2626; color:white; font-size:12px; cursor:pointer;\">Overwrite</button>\n" +
+	"            <button data-policy=\"rename\" style=\"padding:4px 10px; border-radius:999px; border:none; background:#4f46e5; color:white; font-size:12px; cursor:pointer;\">Keep both</button>\n" +
+	"            <button data-policy=\"skip\" style=\"padding:4px 10px; border-radius:999px; border:none; background:#e5e7eb; color:#111827; font-size:12px; cursor:pointer;\">Skip existing</button>\n" +
+	"            <button data-policy=\"cancel\" style=\"padding:4px 10px; border-radius:999px; border:none; background:#9ca3af; color:white; font-size:12px; cursor:pointer;\">Cancel</button>\n" +
+	"          </div>\n" +
+	"        </div>\n" +
 	"        <pre id=\"fsUploadResult\" style=\"margin:6px 0 0; font-size:12px; white-space:pre-wrap;\"></pre>\n" +
 	"      </div>\n" +
 	"\n" +
 	"      <div id=\"fsSelection\" style=\"margin-bottom:6px; font-size:12px; color:#6b7280;\">No item selected. Click a file or folder to select.</div>\n" +
 	"      <ul id=\"fsList\" style=\"list-style:none; padding-left:0; margin:0;\"></ul>\n" +
+	"      <div id=\"fsTrashView\" style=\"display:none;\">\n" +
+	"        <div style=\"display:flex; justify-content:space-between; align-items:center; gap:8px; flex-wrap:wrap; margin-bottom:6px;\">\n" +
+	"          <div id=\"fsTrashInfo\" style=\"font-size:12px; color:#6b7280;\"></div>\n" +
+	"          <div style=\"display:flex; gap:6px;\">\n" +
+	"            <button id=\"fsTrashEmptyBtn\" style=\"padding:4px 10px; border-radius:999px; border:none; background:#dc2626; color:white; font-size:12px; cursor:pointer;\">Empty trash</button>\n" +
+	"            <button id=\"fsTrashBackBtn\" style=\"padding:4px 10px; border-radius:999px; border:none; background:#9ca3af; color:white; font-size:12px; cursor:pointer;\">Back to files</button>\n" +
+	"          </div>\n" +
+	"        </div>\n" +
+	"        <ul id=\"fsTrashList\" style=\"list-style:none; padding-left:0; margin:0;\"></ul>\n" +
+	"      </div>\n" +
+	"      <div id=\"fsSearchView\" style=\"display:none;\">\n" +
+	"        <div style=\"display:flex; justify-content:space-between; align-items:center; gap:8px; flex-wrap:wrap; margin-bottom:6px;\">\n" +
+	"          <div id=\"fsSearchInfo\" style=\"font-size:12px; color:#6b7280;\"></div>\n" +
+	"          <button id=\"fsSearchBackBtn\" style=\"padding:4px 10px; border-radius:999px; border:none; background:#9ca3af; color:white; font-size:12px; cursor:pointer;\">Back to files</button>\n" +
+	"        </div>\n" +
+	"        <ul id=\"fsSearchList\" style=\"list-style:none; padding-left:0; margin:0;\"></ul>\n" +
+	"      </div>\n" +
 	"    </div>\n" +
 	"  </div>\n" +
 	"\n" +
@@ -381,28 +438,82 @@ const pageTemplate = "" +
 	"var fsList = document.getElementById('fsList');\n" +
 	"var fsPath = document.getElementById('fsPath');\n" +
 	"var fsZipLink = document.getElementById('fsZipLink');\n" +
+	"var fsZipSelBtn = document.getElementById('fsZipSelBtn');\n" +
+	"var fsFormatSelect = document.getElementById('fsFormatSelect');\n" +
 	"var fsUpBtn = document.getElementById('fsUpBtn');\n" +
 	"var fsCloseBtn = document.getElementById('fsCloseBtn');\n" +
 	"var fsNewBtn = document.getElementById('fsNewBtn');\n" +
 	"var fsUploadBtn = document.getElementById('fsUploadBtn');\n" +
 	"var fsUploadInput = document.getElementById('fsUploadInput');\n" +
+	"var fsUploadDirBtn = document.getElementById('fsUploadDirBtn');\n" +
+	"var fsUploadDirInput = document.getElementById('fsUploadDirInput');\n" +
 	"var fsSelection = document.getElementById('fsSelection');\n" +
 	"var fsUploadPanel = document.getElementById('fsUploadPanel');\n" +
 	"var fsUploadProg = document.getElementById('fsUploadProg');\n" +
 	"var fsUploadPercent = document.getElementById('fsUploadPercent');\n" +
 	"var fsUploadSpeed = document.getElementById('fsUploadSpeed');\n" +
 	"var fsUploadResult = document.getElementById('fsUploadResult');\n" +
+	"var fsShareSelect = document.getElementById('fsShareSelect');\n" +
+	"var fsConflictBox = document.getElementById('fsConflictBox');\n" +
+	"var fsHashBtn = document.getElementById('fsHashBtn');\n" +
+	"var fsExtractBtn = document.getElementById('fsExtractBtn');\n" +
+	"var fsDeleteBtn = document.getElementById('fsDeleteBtn');\n" +
+	"var fsRenameBtn = document.getElementById('fsRenameBtn');\n" +
+	"var fsMoveBtn = document.getElementById('fsMoveBtn');\n" +
+	"var fsCopyBtn = document.getElementById('fsCopyBtn');\n" +
+	"var fsTrashBtn = document.getElementById('fsTrashBtn');\n" +
+	"var fsTrashView = document.getElementById('fsTrashView');\n" +
+	"var fsTrashInfo = document.getElementById('fsTrashInfo');\n" +
+	"var fsTrashList = document.getElementById('fsTrashList');\n" +
+	"var fsSearchInput = document.getElementById('fsSearchInput');\n" +
+	"var fsSearchType = document.getElementById('fsSearchType');\n" +
+	"var fsSearchMode = document.getElementById('fsSearchMode');\n" +
+	"var fsSearchView = document.getElementById('fsSearchView');\n" +
+	"var fsSearchInfo = document.getElementById('fsSearchInfo');\n" +
+	"var fsSearchList = document.getElementById('fsSearchList');\n" +
+	"var fsConflictText = document.getElementById('fsConflictText');\n" +
 	"\n" +
+	"var currentShare = '';\n" +
+	"var currentReadOnly = false;\n" +
 	"var currentFsDir = '';\n" +
 	"var selectedItemPath = '';\n" +
 	"var selectedItemType = '';\n" +
 	"var selectedLi = null;\n" +
+	"var checkedPaths = {};\n" +
 	"\n" +
 	"function openBrowserForFolder(rel) {\n" +
 	"  currentFsDir = rel || '';\n" +
 	"  fsModal.style.display = 'block';\n" +
 	"  loadFsDir(currentFsDir);\n" +
 	"}\n" +
+	"\n" +
+	"function shareParam() { return 'share=' + encodeURIComponent(currentShare); }\n" +
+	"\n" +
+	"function loadShares() {\n" +
+	"  fetch('/api/shares').then(function(resp) {\n" +
+	"    if (!resp.ok) { throw new Error('HTTP ' + resp.status); }\n" +
+	"    return resp.json();\n" +
+	"  }).then(function(list) {\n" +
+	"    fsShareSelect.innerHTML = '';\n" +
+	"    (list || []).forEach(function(sh) {\n" +
+	"      var opt = document.createElement('option');\n" +
+	"      opt.value = sh.name;\n" +
+	"      opt.textContent = sh.name + (sh.readOnly ? ' (read-only)' : '');\n" +
+	"      fsShareSelect.appendChild(opt);\n" +
+	"    });\n" +
+	"    if (currentShare) fsShareSelect.value = currentShare;\n" +
+	"    fsShareSelect.style.display = list && list.length > 1 ? 'block' : 'none';\n" +
+	"  }).catch(function() { fsShareSelect.style.display = 'none'; });\n" +
+	"}\n" +
+	"\n" +
+	"function updateWriteButtons() {\n" +
+	"  [fsNewBtn, fsUploadBtn, fsUploadDirBtn, fsExtractBtn, fsRenameBtn, fsMoveBtn, fsCopyBtn, fsDeleteBtn, fsTrashBtn].forEach(function(btn) {\n" +
+	"    if (!btn) return;\n" +
+	"    btn.disabled = currentReadOnly;\n" +
+	"    btn.style.opacity = currentReadOnly ? '0.5' : '1';\n" +
+	"    btn.style.cursor = currentReadOnly ? 'default' : 'pointer';\n" +
+	"  });\n" +
+	"}\n" +
 	"function closeFsModal() { fsModal.style.display = 'none'; }\n" +
 	"\n" +
 	"function updateUpButtonState() {\n" +
@@ -449,7 +560,7 @@ const pageTemplate = "" +
 	"    if (entry.isDir) {\n" +
 	"      openBrowserForFolder(entry.relPath);\n" +
 	"    } else {\n" +
-	"      window.location = '/download?file=' + encodeURIComponent(entry.relPath);\n" +
+	"      window.location = '/download?' + shareParam() + '&file=' + encodeURIComponent(entry.relPath);\n" +
 	"    }\n" +
 	"    return;\n" +
 	"  }\n" +
@@ -466,26 +577,78 @@ const pageTemplate = "" +
 	"  updateSelectionText();\n" +
 	"}\n" +
 	"\n" +
+	"// 打包格式记在 localStorage 里；ZIP 用不压缩的版本（有 Content-Length，断了能续传）\n" +
+	"function archiveFormat() { return fsFormatSelect ? fsFormatSelect.value : 'zip'; }\n" +
+	"\n" +
+	"function updateZipHref() {\n" +
+	"  var zipHref = '/download-zip?' + shareParam() + '&format=' + encodeURIComponent(archiveFormat());\n" +
+	"  if (archiveFormat() === 'zip') zipHref += '&level=none';\n" +
+	"  if (currentFsDir && currentFsDir.length > 0) {\n" +
+	"    zipHref += '&dir=' + encodeURIComponent(currentFsDir);\n" +
+	"  }\n" +
+	"  fsZipLink.href = zipHref;\n" +
+	"}\n" +
+	"\n" +
+	"// 勾选的多个文件 / 文件夹打成一个包：用表单 POST，浏览器自己处理下载\n" +
+	"function checkedList() { return Object.keys(checkedPaths); }\n" +
+	"\n" +
+	"function updateZipSelBtn() {\n" +
+	"  if (!fsZipSelBtn) return;\n" +
+	"  var n = checkedList().length;\n" +
+	"  fsZipSelBtn.style.display = n ? 'inline-block' : 'none';\n" +
+	"  fsZipSelBtn.textContent = 'Download selected (' + n + ')';\n" +
+	"}\n" +
+	"\n" +
+	"function downloadSelection() {\n" +
+	"  var list = checkedList();\n" +
+	"  if (!list.length) return;\n" +
+	"  var form = document.createElement('form');\n" +
+	"  form.method = 'POST';\n" +
+	"  form.action = '/download-selection';\n" +
+	"  form.style.display = 'none';\n" +
+	"  function addField(name, value) {\n" +
+	"    var input = document.createElement('input');\n" +
+	"    input.type = 'hidden';\n" +
+	"    input.name = name;\n" +
+	"    input.value = value;\n" +
+	"    form.appendChild(input);\n" +
+	"  }\n" +
+	"  addField('share', currentShare);\n" +
+	"  addField('format', archiveFormat());\n" +
+	"  if (archiveFormat() === 'zip') addField('level', 'none');\n" +
+	"  list.forEach(function(p) { addField('paths', p); });\n" +
+	"  document.body.appendChild(form);\n" +
+	"  form.submit();\n" +
+	"  document.body.removeChild(form);\n" +
+	"}\n" +
+	"\n" +
 	"function loadFsDir(rel) {\n" +
-	"  var url = '/api/list';\n" +
+	"  var url = '/api/list?' + shareParam();\n" +
 	"  if (rel && rel.length > 0) {\n" +
-	"    url += '?dir=' + encodeURIComponent(rel);\n" +
+	"    url += '&dir=' + encodeURIComponent(rel);\n" +
 	"  }\n" +
 	"  fetch(url).then(function(resp) {\n" +
 	"    if (!resp.ok) { throw new Error('HTTP ' + resp.status); }\n" +
 	"    return resp.json();\n" +
 	"  }).then(function(data) {\n" +
+	"    stopSearch();\n" +
+	"    fsTrashView.style.display = 'none';\n" +
+	"    fsSearchView.style.display = 'none';\n" +
+	"    fsList.style.display = 'block';\n" +
+	"    fsSelection.style.display = 'block';\n" +
 	"    fsPath.textContent = data.displayPath;\n" +
 	"    if (data.dir !== undefined) {\n" +
 	"      currentFsDir = data.dir || '';\n" +
 	"    }\n" +
-	"    var zipHref = '/download-zip';\n" +
-	"    if (currentFsDir && currentFsDir.length > 0) {\n" +
-	"      zipHref += '?dir=' + encodeURIComponent(currentFsDir);\n" +
-	"    }\n" +
-	"    fsZipLink.href = zipHref;\n" +
+	"    currentShare = data.share || '';\n" +
+	"    currentReadOnly = !!data.readOnly;\n" +
+	"    if (fsShareSelect.value !== currentShare) fsShareSelect.value = currentShare;\n" +
+	"    updateZipHref();\n" +
 	"    updateUpButtonState();\n" +
+	"    updateWriteButtons();\n" +
 	"    clearSelection();\n" +
+	"    checkedPaths = {};\n" +
+	"    updateZipSelBtn();\n" +
 	"\n" +
 	"    fsList.innerHTML = '';\n" +
 	"    if (!data.entries || data.entries.length === 0) {\n" +
@@ -502,6 +665,16 @@ const pageTemplate = "" +
 	"      li.style.padding = '4px 6px';\n" +
 	"      li.style.borderRadius = '6px';\n" +
 	"\n" +
+	"      var cb = document.createElement('input');\n" +
+	"      cb.type = 'checkbox';\n" +
+	"      cb.style.marginRight = '6px';\n" +
+	"      cb.onclick = function(ev) {\n" +
+	"        ev.stopPropagation();\n" +
+	"        if (cb.checked) checkedPaths[e.relPath] = true; else delete checkedPaths[e.relPath];\n" +
+	"        updateZipSelBtn();\n" +
+	"      };\n" +
+	"      li.appendChild(cb);\n" +
+	"\n" +
 	"      var label = document.createElement('span');\n" +
 	"      label.style.marginRight = '6px';\n" +
 	"      label.textContent = e.isDir ? '[Dir]' : '[File]';\n" +
@@ -543,45 +716,636 @@ const pageTemplate = "" +
 	"  fsUploadResult.textContent = '';\n" +
 	"}\n" +
 	"\n" +
+	"// 断点续传（tus 1.0）：按块 PATCH，断线自动重试，刷新页面后凭 localStorage 里的地址继续\n" +
+	"var TUS_CHUNK_SIZE = 8 * 1024 * 1024;\n" +
+	"var TUS_MAX_RETRIES = 30;\n" +
+	"\n" +
+	"function b64utf8(s) { return btoa(unescape(encodeURIComponent(s))); }\n" +
+	"\n" +
+	"function tusRequest(method, url, headers, body, onprogress) {\n" +
+	"  return new Promise(function(resolve, reject) {\n" +
+	"    var xhr = new XMLHttpRequest();\n" +
+	"    xhr.open(method, url, true);\n" +
+	"    xhr.setRequestHeader('Tus-Resumable', '1.0.0');\n" +
+	"    for (var k in headers) { xhr.setRequestHeader(k, headers[k]); }\n" +
+	"    if (onprogress) xhr.upload.onprogress = onprogress;\n" +
+	"    xhr.onload = function() { resolve(xhr); };\n" +
+	"    xhr.onerror = function() { reject(new Error('network error')); };\n" +
+	"    xhr.send(body || null);\n" +
+	"  });\n" +
+	"}\n" +
+	"\n" +
+	"function tusError(xhr) {\n" +
+	"  var err = new Error('HTTP ' + xhr.status + (xhr.responseText ? ': ' + xhr.responseText.trim() : ''));\n" +
+	"  // 4xx 里只有偏移冲突 / 被占用 / 过期值得重试，同名冲突和其余错误重试也没用\n" +
+	"  var conflict = xhr.getResponseHeader('Upload-Conflict');\n" +
+	"  err.conflict = conflict || '';\n" +
+	"  err.fatal = !!conflict || (xhr.status >= 400 && xhr.status < 500 && [404, 409, 423].indexOf(xhr.status) === -1);\n" +
+	"  return err;\n" +
+	"}\n" +
+	"\n" +
+	"function tusStorageKey(item, share, target) {\n" +
+	"  return 'ft-tus:' + [share, target, item.path, item.file.size, item.file.lastModified].join('|');\n" +
+	"}\n" +
+	"\n" +
+	"function tusResult(xhr) {\n" +
+	"  return {\n" +
+	"    outcome: xhr.getResponseHeader('Upload-Conflict') || '',\n" +
+	"    path: xhr.getResponseHeader('Upload-Path') || '',\n" +
+	"    sha256: xhr.getResponseHeader('Upload-Sha256') || ''\n" +
+	"  };\n" +
+	"}\n" +
+	"\n" +
+	"// WebCrypto 只能一次性算整个 ArrayBuffer，太大的文件就不在浏览器里算了；\n" +
+	"// 另外它只在 HTTPS 或 localhost 下可用，普通局域网 http 访问时算不了，结果里会注明没校验\n" +
+	"var HASH_MAX_BYTES = 256 * 1024 * 1024;\n" +
+	"function sha256Unavailable(file) {\n" +
+	"  if (!window.crypto || !crypto.subtle || !file.arrayBuffer) return 'WebCrypto unavailable';\n" +
+	"  if (file.size > HASH_MAX_BYTES) return 'file too large to hash in the browser';\n" +
+	"  return '';\n" +
+	"}\n" +
+	"\n" +
+	"function sha256Hex(file) {\n" +
+	"  if (sha256Unavailable(file)) return Promise.resolve('');\n" +
+	"  return file.arrayBuffer().then(function(buf) {\n" +
+	"    return crypto.subtle.digest('SHA-256', buf);\n" +
+	"  }).then(function(digest) {\n" +
+	"    return Array.prototype.map.call(new Uint8Array(digest), function(b) { return ('0' + b.toString(16)).slice(-2); }).join('');\n" +
+	"  }).catch(function() { return ''; });\n" +
+	"}\n" +
+	"\n" +
+	"function tusResumeOrCreate(item, share, target, policy) {\n" +
+	"  var file = item.file;\n" +
+	"  var key = tusStorageKey(item, share, target);\n" +
+	"  var create = function() {\n" +
+	"    var meta = 'filename ' + b64utf8(file.name) + ',relativePath ' + b64utf8(item.path) + ',share ' + b64utf8(share) + ',target ' + b64utf8(target);\n" +
+	"    if (policy) meta += ',conflict ' + b64utf8(policy);\n" +
+	"    return sha256Hex(file).then(function(sum) {\n" +
+	"      item.sha256 = sum;\n" +
+	"      if (!sum) item.hashNote = sha256Unavailable(file) || 'hashing failed';\n" +
+	"      if (sum) meta += ',sha256 ' + b64utf8(sum);\n" +
+	"      return tusRequest('POST', '/tus/', { 'Upload-Length': String(file.size), 'Upload-Metadata': meta });\n" +
+	"    }).then(function(xhr) {\n" +
+	"      if (xhr.status !== 201) throw tusError(xhr);\n" +
+	"      var url = xhr.getResponseHeader('Location');\n" +
+	"      try { localStorage.setItem(key, url); } catch (e) {}\n" +
+	"      return { url: url, offset: 0, result: tusResult(xhr) };\n" +
+	"    });\n" +
+	"  };\n" +
+	"  var saved = null;\n" +
+	"  try { saved = localStorage.getItem(key); } catch (e) {}\n" +
+	"  if (!saved) return create();\n" +
+	"  return tusRequest('HEAD', saved, {}).then(function(xhr) {\n" +
+	"    if (xhr.status === 200) {\n" +
+	"      return { url: saved, offset: parseInt(xhr.getResponseHeader('Upload-Offset'), 10) || 0, result: tusResult(xhr) };\n" +
+	"    }\n" +
+	"    try { localStorage.removeItem(key); } catch (e) {}\n" +
+	"    return create();\n" +
+	"  });\n" +
+	"}\n" +
+	"\n" +
+	"// onProgress(uploadedBytesOfThisFile, resumedFrom)；完成后返回 { outcome, path }\n" +
+	"function tusUploadFile(item, share, target, policy, onProgress, onRetry) {\n" +
+	"  var file = item.file;\n" +
+	"  var key = tusStorageKey(item, share, target);\n" +
+	"  var attempt = 0;\n" +
+	"\n" +
+	"  function sendFrom(url, offset, resumedFrom, result) {\n" +
+	"    // 数据都到了但服务端还没落盘完成（没有 outcome）时，再发一个空 PATCH 让它收尾\n" +
+	"    if (offset >= file.size && result.outcome) return Promise.resolve(result);\n" +
+	"    var end = Math.min(offset + TUS_CHUNK_SIZE, file.size);\n" +
+	"    var headers = { 'Content-Type': 'application/offset+octet-stream', 'Upload-Offset': String(offset) };\n" +
+	"    return tusRequest('PATCH', url, headers, file.slice(offset, end), function(e) {\n" +
+	"      onProgress(offset + e.loaded, resumedFrom);\n" +
+	"    }).then(function(xhr) {\n" +
+	"      if (xhr.status !== 204) throw tusError(xhr);\n" +
+	"      attempt = 0;\n" +
+	"      var next = parseInt(xhr.getResponseHeader('Upload-Offset'), 10);\n" +
+	"      onProgress(next, resumedFrom);\n" +
+	"      return sendFrom(url, next, resumedFrom, tusResult(xhr));\n" +
+	"    });\n" +
+	"  }\n" +
+	"\n" +
+	"  function run() {\n" +
+	"    return tusResumeOrCreate(item, share, target, policy).then(function(st) {\n" +
+	"      onProgress(st.offset, st.offset);\n" +
+	"      return sendFrom(st.url, st.offset, st.offset, st.result);\n" +
+	"    }).catch(function(err) {\n" +
+	"      if (err.conflict === 'skipped') return { outcome: 'skipped', path: '' };\n" +
+	"      if (err.fatal || attempt >= TUS_MAX_RETRIES) throw err;\n" +
+	"      attempt++;\n" +
+	"      var delay = Math.min(1000 * Math.pow(2, attempt - 1), 30000);\n" +
+	"      onRetry(attempt, delay, err);\n" +
+	"      return new Promise(function(r) { setTimeout(r, delay); }).then(run);\n" +
+	"    });\n" +
+	"  }\n" +
+	"\n" +
+	"  return run().then(function(result) {\n" +
+	"    try { localStorage.removeItem(key); } catch (e) {}\n" +
+	"    return result;\n" +
+	"  }, function(err) {\n" +
+	"    if (err.fatal) { try { localStorage.removeItem(key); } catch (e) {} }\n" +
+	"    throw err;\n" +
+	"  });\n" +
+	"}\n" +
+	"\n" +
+	"// 目标目录里已有同名文件时让用户选：覆盖 / 都保留 / 跳过 / 取消\n" +
+	"function askConflictPolicy(existing) {\n" +
+	"  return new Promise(function(resolve) {\n" +
+	"    var shown = existing.slice(0, 5).join(', ') + (existing.length > 5 ? ' ...' : '');\n" +
+	"    fsConflictText.textContent = existing.length + ' file(s) already exist in this folder: ' + shown;\n" +
+	"    fsConflictBox.style.display = 'block';\n" +
+	"    function done(policy) {\n" +
+	"      fsConflictBox.style.display = 'none';\n" +
+	"      fsConflictBox.onclick = null;\n" +
+	"      resolve(policy);\n" +
+	"    }\n" +
+	"    fsConflictBox.onclick = function(e) {\n" +
+	"      var policy = e.target && e.target.getAttribute('data-policy');\n" +
+	"      if (policy === null || policy === undefined) return;\n" +
+	"      done(policy === 'cancel' ? null : policy);\n" +
+	"    };\n" +
+	"  });\n" +
+	"}\n" +
+	"\n" +
+	"function checkExisting(share, target, names) {\n" +
+	"  return fetch('/api/exists', {\n" +
+	"    method: 'POST',\n" +
+	"    headers: { 'Content-Type': 'application/json' },\n" +
+	"    body: JSON.stringify({ share: share, target: target, names: names })\n" +
+	"  }).then(function(resp) {\n" +
+	"    if (!resp.ok) return [];\n" +
+	"    return resp.json().then(function(data) { return data.existing || []; });\n" +
+	"  }).catch(function() { return []; });\n" +
+	"}\n" +
+	"\n" +
+	"// files 来自文件选择框或文件夹选择框（webkitRelativePath 保留目录结构）\n" +
 	"function uploadToCurrentDir(files) {\n" +
 	"  if (!files || files.length === 0) return;\n" +
+	"  var items = Array.prototype.map.call(files, function(f) {\n" +
+	"    return { file: f, path: f.webkitRelativePath || f.name };\n" +
+	"  });\n" +
+	"  uploadItems(items);\n" +
+	"}\n" +
+	"\n" +
+	"// items: [{ file: File, path: 'dir/sub/name.ext' }]\n" +
+	"function uploadItems(list) {\n" +
+	"  if (!list || list.length === 0) return;\n" +
+	"  if (currentReadOnly) { alert('This share is read-only.'); return; }\n" +
 	"  showUploadPanel();\n" +
 	"  resetUploadPanel();\n" +
 	"\n" +
-	"  var formData = new FormData();\n" +
-	"  for (var i = 0; i < files.length; i++) {\n" +
-	"    formData.append('files', files[i]);\n" +
-	"  }\n" +
-	"  formData.append('target', currentFsDir);\n" +
+	"  var share = currentShare;\n" +
+	"  var target = currentFsDir;\n" +
+	"  fsUploadResult.textContent = 'Checking for existing files ...';\n" +
+	"  checkExisting(share, target, list.map(function(it) { return it.path; })).then(function(existing) {\n" +
+	"    if (existing.length === 0) return '';\n" +
+	"    fsUploadResult.textContent = '';\n" +
+	"    return askConflictPolicy(existing);\n" +
+	"  }).then(function(policy) {\n" +
+	"    if (policy === null) {\n" +
+	"      fsUploadResult.textContent = 'Upload cancelled.';\n" +
+	"      return;\n" +
+	"    }\n" +
+	"    startUploads(list, share, target, policy);\n" +
+	"  });\n" +
+	"}\n" +
 	"\n" +
-	"  var xhr = new XMLHttpRequest();\n" +
-	"  xhr.open('POST', '/upload', true);\n" +
+	"function startUploads(list, share, target, policy) {\n" +
+	"  var totalBytes = 0;\n" +
+	"  list.forEach(function(it) { totalBytes += it.file.size; });\n" +
+	"  var doneBytes = 0;\n" +
+	"  var skippedBytes = 0;\n" +
 	"  var startTime = Date.now();\n" +
+	"  var lines = [];\n" +
 	"\n" +
-	"  xhr.upload.onprogress = function(e) {\n" +
-	"    if (!e.lengthComputable) return;\n" +
-	"    var percent = e.loaded / e.total * 100;\n" +
+	"  function showProgress(loaded) {\n" +
+	"    var percent = totalBytes > 0 ? loaded / totalBytes * 100 : 100;\n" +
 	"    fsUploadProg.value = percent;\n" +
 	"    fsUploadPercent.textContent = percent.toFixed(1);\n" +
 	"    var elapsedSec = (Date.now() - startTime) / 1000;\n" +
 	"    if (elapsedSec > 0) {\n" +
-	"      var bytesPerSec = e.loaded / elapsedSec;\n" +
+	"      var bytesPerSec = Math.max(0, loaded - skippedBytes) / elapsedSec;\n" +
 	"      var mbPerSec = bytesPerSec / (1024 * 1024);\n" +
 	"      fsUploadSpeed.textContent = mbPerSec.toFixed(2) + ' MB/s';\n" +
 	"    }\n" +
-	"  };\n" +
+	"  }\n" +
+	"  function showStatus(extra) {\n" +
+	"    fsUploadResult.textContent = lines.concat(extra ? [extra] : []).join('\\n');\n" +
+	"  }\n" +
 	"\n" +
-	"  xhr.onload = function() {\n" +
-	"    if (xhr.status === 200) {\n" +
-	"      fsUploadResult.textContent = xhr.responseText;\n" +
-	"      loadFsDir(currentFsDir);\n" +
-	"    } else {\n" +
-	"      fsUploadResult.textContent = 'Upload failed: ' + xhr.status + ' ' + xhr.statusText + '\\n' + xhr.responseText;\n" +
+	"  var i = 0;\n" +
+	"  function next() {\n" +
+	"    if (i >= list.length) {\n" +
+	"      showStatus('');\n" +
+	"      if (target === currentFsDir && share === currentShare) loadFsDir(currentFsDir);\n" +
+	"      return;\n" +
+	"    }\n" +
+	"    var item = list[i++];\n" +
+	"    var file = item.file;\n" +
+	"    var name = item.path;\n" +
+	"    var counted = false;\n" +
+	"    showStatus('Uploading ' + name + ' ...');\n" +
+	"    return tusUploadFile(item, share, target, policy, function(n, resumedFrom) {\n" +
+	"      if (!counted && resumedFrom > 0) {\n" +
+	"        counted = true;\n" +
+	"        skippedBytes += resumedFrom;\n" +
+	"        showStatus('Resuming ' + name + ' from ' + resumedFrom + ' bytes ...');\n" +
+	"      }\n" +
+	"      showProgress(doneBytes + n);\n" +
+	"    }, function(attempt, delay, err) {\n" +
+	"      showStatus('Retrying ' + name + ' in ' + Math.round(delay / 1000) + 's (attempt ' + attempt + ', ' + err.message + ')');\n" +
+	"    }).then(function(result) {\n" +
+	"      if (result.outcome === 'skipped') {\n" +
+	"        lines.push('SKIPPED: ' + name + ' (already exists)');\n" +
+	"        return;\n" +
+	"      }\n" +
+	"      var line = 'OK: ' + name;\n" +
+	"      if (result.outcome === 'renamed' || result.outcome === 'overwritten') {\n" +
+	"        line += ' -> ' + result.path + ' (' + result.outcome + ')';\n" +
+	"      }\n" +
+	"      if (item.sha256 && result.sha256 === item.sha256) {\n" +
+	"        line += ' [sha256 verified]';\n" +
+	"      } else {\n" +
+	"        if (item.hashNote) line += ' [not verified (' + item.hashNote + ')]';\n" +
+	"        if (result.sha256) line += ' sha256=' + result.sha256;\n" +
+	"      }\n" +
+	"      lines.push(line);\n" +
+	"    }, function(err) {\n" +
+	"      lines.push('FAILED: ' + name + ' (' + err.message + ')');\n" +
+	"    }).then(function() {\n" +
+	"      doneBytes += file.size;\n" +
+	"      showProgress(doneBytes);\n" +
+	"      return next();\n" +
+	"    });\n" +
+	"  }\n" +
+	"  next();\n" +
+	"}\n" +
+	"\n" +
+	"// 服务端算选中文件的 SHA-256，下载后可以和本地算的对照\n" +
+	"function showSelectedChecksum() {\n" +
+	"  if (selectedItemType !== 'file') { alert('Select a file first.'); return; }\n" +
+	"  var rel = selectedItemPath;\n" +
+	"  showUploadPanel();\n" +
+	"  fsUploadResult.textContent = 'Computing SHA-256 of ' + rel + ' ...';\n" +
+	"  fetch('/api/hash?' + shareParam() + '&algo=sha256&file=' + encodeURIComponent(rel)).then(function(resp) {\n" +
+	"    if (!resp.ok) return resp.text().then(function(t) { throw new Error(t || ('HTTP ' + resp.status)); });\n" +
+	"    return resp.json();\n" +
+	"  }).then(function(data) {\n" +
+	"    fsUploadResult.textContent = 'SHA-256 of ' + data.file + ' (' + data.size + ' bytes):\\n' + data.hash;\n" +
+	"  }).catch(function(err) { fsUploadResult.textContent = 'Checksum failed: ' + err; });\n" +
+	"}\n" +
+	"\n" +
+	"// 在服务端解压选中的压缩包，默认解到旁边同名的文件夹，同名文件按服务端默认策略处理\n" +
+	"function extractSelected() {\n" +
+	"  var m = /^(.*?)(\\.zip|\\.tar|\\.tgz|\\.tar\\.gz|\\.tar\\.zst|\\.tzst|\\.tar\\.bz2|\\.tbz2)$/i.exec(selectedItemPath);\n" +
+	"  if (selectedItemType !== 'file' || !m) { alert('Select a ZIP or tar archive first.'); return; }\n" +
+	"  var target = window.prompt('Extract ' + selectedItemPath + ' into folder:', m[1]);\n" +
+	"  if (target === null) return;\n" +
+	"  target = target.trim();\n" +
+	"  if (target.indexOf('..') !== -1 || target.startsWith('/')) { alert('Invalid folder'); return; }\n" +
+	"  showUploadPanel();\n" +
+	"  fsUploadResult.textContent = 'Extracting ' + selectedItemPath + ' ...';\n" +
+	"  fetch('/api/extract', {\n" +
+	"    method: 'POST',\n" +
+	"    headers: { 'Content-Type': 'application/json' },\n" +
+	"    body: JSON.stringify({ share: currentShare, archive: selectedItemPath, target: target })\n" +
+	"  }).then(function(resp) {\n" +
+	"    return resp.json().catch(function() { return { error: 'HTTP ' + resp.status }; }).then(function(data) {\n" +
+	"      if (!data.files) throw new Error(data.error || ('HTTP ' + resp.status));\n" +
+	"      return data;\n" +
+	"    });\n" +
+	"  }).then(function(data) {\n" +
+	"    var lines = ['Extracted ' + data.succeeded + ' file(s) into ' + (data.target || '/') +\n" +
+	"      (data.skipped ? ', skipped ' + data.skipped : '') + (data.failed ? ', failed ' + data.failed : '')];\n" +
+	"    data.files.forEach(function(f) {\n" +
+	"      if (f.status !== 'ok') lines.push(f.status.toUpperCase() + ': ' + f.name + ' (' + f.error + ')');\n" +
+	"    });\n" +
+	"    (data.errors || []).forEach(function(e) { lines.push('FAILED: ' + e.error); });\n" +
+	"    fsUploadResult.textContent = lines.join('\\n');\n" +
+	"    loadFsDir(currentFsDir);\n" +
+	"  }).catch(function(err) { fsUploadResult.textContent = 'Extract failed: ' + err.message; });\n" +
+	"}\n" +
+	"\n" +
+	"function deleteLine(f) {\n" +
+	"  if (f.status !== 'ok') return 'FAILED: ' + f.name + ' (' + f.error + ')';\n" +
+	"  return (f.outcome === 'trashed' ? 'MOVED TO TRASH: ' : 'DELETED: ') + f.path;\n" +
+	"}\n" +
+	"\n" +
+	"// 删除勾选的项目，没勾选就删选中的那个；非空文件夹服务端会拒绝，再确认一次才连里面的内容一起删\n" +
+	"function deleteSelected() {\n" +
+	"  var list = checkedList();\n" +
+	"  if (!list.length && selectedItemPath) list = [selectedItemPath];\n" +
+	"  if (!list.length) { alert('Select or check the items to delete first.'); return; }\n" +
+	"  var what = list.length === 1 ? list[0] : list.length + ' items';\n" +
+	"  if (!window.confirm('Delete ' + what + '?')) return;\n" +
+	"  var lines = [];\n" +
+	"  function send(paths, recursive) {\n" +
+	"    return fetch('/api/delete', {\n" +
+	"      method: 'POST',\n" +
+	"      headers: { 'Content-Type': 'application/json' },\n" +
+	"      body: JSON.stringify({ share: currentShare, paths: paths, recursive: recursive })\n" +
+	"    }).then(function(resp) {\n" +
+	"      return resp.json().catch(function() { return { error: 'HTTP ' + resp.status }; }).then(function(data) {\n" +
+	"        if (!data.files) throw new Error(data.error || ('HTTP ' + resp.status));\n" +
+	"        return data;\n" +
+	"      });\n" +
+	"    });\n" +
+	"  }\n" +
+	"  showUploadPanel();\n" +
+	"  fsUploadResult.textContent = 'Deleting ' + what + ' ...';\n" +
+	"  send(list, false).then(function(data) {\n" +
+	"    var notEmpty = [];\n" +
+	"    data.files.forEach(function(f) {\n" +
+	"      if (f.code === 'not_empty') notEmpty.push(f.name);\n" +
+	"      else lines.push(deleteLine(f));\n" +
+	"    });\n" +
+	"    if (!notEmpty.length) return;\n" +
+	"    if (!window.confirm('These folders are not empty:\\n' + notEmpty.join('\\n') + '\\n\\nDelete them with everything inside?')) {\n" +
+	"      notEmpty.forEach(function(p) { lines.push('KEPT: ' + p + ' (not empty)'); });\n" +
+	"      return;\n" +
+	"    }\n" +
+	"    return send(notEmpty, true).then(function(data) {\n" +
+	"      data.files.forEach(function(f) {\n" +
+	"        lines.push(deleteLine(f));\n" +
+	"      });\n" +
+	"    });\n" +
+	"  }).then(function() {\n" +
+	"    fsUploadResult.textContent = lines.join('\\n');\n" +
+	"    loadFsDir(currentFsDir);\n" +
+	"  }).catch(function(err) { fsUploadResult.textContent = 'Delete failed: ' + err.message; });\n" +
+	"}\n" +
+	"\n" +
+	"// 发一个批量操作的 JSON 请求，返回每一项的结果（response.go 的 resultList）\n" +
+	"function postBatch(url, body) {\n" +
+	"  return fetch(url, {\n" +
+	"    method: 'POST',\n" +
+	"    headers: { 'Content-Type': 'application/json' },\n" +
+	"    body: JSON.stringify(body)\n" +
+	"  }).then(function(resp) {\n" +
+	"    return resp.json().catch(function() { return { error: 'HTTP ' + resp.status }; }).then(function(data) {\n" +
+	"      if (!data.files) throw new Error(data.error || ('HTTP ' + resp.status));\n" +
+	"      return data;\n" +
+	"    });\n" +
+	"  });\n" +
+	"}\n" +
+	"\n" +
+	"function showBatchResult(verb, data) {\n" +
+	"  showUploadPanel();\n" +
+	"  fsUploadResult.textContent = data.files.map(function(f) {\n" +
+	"    if (f.status === 'ok') return verb + ': ' + f.name + ' -> ' + f.path + (f.outcome === 'renamed' ? ' (renamed)' : '');\n" +
+	"    return f.status.toUpperCase() + ': ' + f.name + ' (' + f.error + ')';\n" +
+	"  }).join('\\n');\n" +
+	"  loadFsDir(currentFsDir);\n" +
+	"}\n" +
+	"\n" +
+	"function actionTargets() {\n" +
+	"  var list = checkedList();\n" +
+	"  if (!list.length && selectedItemPath) list = [selectedItemPath];\n" +
+	"  return list;\n" +
+	"}\n" +
+	"\n" +
+	"// 改名：选中的一项（或者唯一勾选的一项），原地改\n" +
+	"function renameSelected() {\n" +
+	"  var list = actionTargets();\n" +
+	"  if (list.length !== 1) { alert('Select one item to rename.'); return; }\n" +
+	"  var oldName = list[0].split('/').pop();\n" +
+	"  var name = window.prompt('New name for ' + list[0] + ':', oldName);\n" +
+	"  if (name === null) return;\n" +
+	"  name = name.trim();\n" +
+	"  if (!name || name === oldName) return;\n" +
+	"  if (name.indexOf('/') !== -1 || name.indexOf('\\\\') !== -1) { alert('The name cannot contain / or \\\\'); return; }\n" +
+	"  postBatch('/api/move', { share: currentShare, paths: list, name: name }).then(function(data) {\n" +
+	"    showBatchResult('RENAMED', data);\n" +
+	"  }).catch(function(err) { alert('Rename failed: ' + err.message); });\n" +
+	"}\n" +
+	"\n" +
+	"// 移动：勾选的（没勾选就选中的那个）挪到另一个文件夹，路径相对共享根目录\n" +
+	"function moveSelected() {\n" +
+	"  var list = actionTargets();\n" +
+	"  if (!list.length) { alert('Select or check the items to move first.'); return; }\n" +
+	"  var target = window.prompt('Move ' + (list.length === 1 ? list[0] : list.length + ' items') + ' to folder (relative to the share root, empty = root):', currentFsDir);\n" +
+	"  if (target === null) return;\n" +
+	"  target = target.trim().replace(/^\\/+|\\/+$/g, '');\n" +
+	"  postBatch('/api/move', { share: currentShare, paths: list, target: target }).then(function(data) {\n" +
+	"    showBatchResult('MOVED', data);\n" +
+	"  }).catch(function(err) { alert('Move failed: ' + err.message); });\n" +
+	"}\n" +
+	"\n" +
+	"// 读一行一个 JSON 的流式返回，每读到完整的一行就交给 onMessage\n" +
+	"function readNDJSON(resp, onMessage) {\n" +
+	"  var reader = resp.body.getReader();\n" +
+	"  var decoder = new TextDecoder();\n" +
+	"  var buf = '';\n" +
+	"  function handleLine(line) {\n" +
+	"    if (line.trim()) onMessage(JSON.parse(line));\n" +
+	"  }\n" +
+	"  function pump() {\n" +
+	"    return reader.read().then(function(chunk) {\n" +
+	"      if (chunk.done) {\n" +
+	"        handleLine(buf);\n" +
+	"        return;\n" +
+	"      }\n" +
+	"      buf += decoder.decode(chunk.value, { stream: true });\n" +
+	"      var lines = buf.split('\\n');\n" +
+	"      buf = lines.pop();\n" +
+	"      lines.forEach(handleLine);\n" +
+	"      return pump();\n" +
+	"    });\n" +
+	"  }\n" +
+	"  return pump();\n" +
+	"}\n" +
+	"\n" +
+	"// 复制：和移动一样选目标文件夹；带 progress=1，一行一个 JSON，边读边更新进度条，最后一行是结果\n" +
+	"function copySelected() {\n" +
+	"  var list = actionTargets();\n" +
+	"  if (!list.length) { alert('Select or check the items to copy first.'); return; }\n" +
+	"  var target = window.prompt('Copy ' + (list.length === 1 ? list[0] : list.length + ' items') + ' to folder (relative to the share root, empty = root):', currentFsDir);\n" +
+	"  if (target === null) return;\n" +
+	"  target = target.trim().replace(/^\\/+|\\/+$/g, '');\n" +
+	"  resetUploadPanel();\n" +
+	"  showUploadPanel();\n" +
+	"  fsUploadResult.textContent = 'Copying...';\n" +
+	"  var startTime = Date.now();\n" +
+	"  var result = null;\n" +
+	"  function handleLine(msg) {\n" +
+	"    if (msg.type === 'result') { result = msg; return; }\n" +
+	"    var percent = msg.total > 0 ? msg.copied * 100 / msg.total : 100;\n" +
+	"    fsUploadProg.value = percent;\n" +
+	"    fsUploadPercent.textContent = percent.toFixed(1);\n" +
+	"    var elapsed = (Date.now() - startTime) / 1000;\n" +
+	"    if (elapsed > 0) fsUploadSpeed.textContent = (msg.copied / elapsed / (1024 * 1024)).toFixed(2) + ' MB/s';\n" +
+	"    fsUploadResult.textContent = 'Copying... ' + msg.files + ' file(s), ' + (msg.copied / (1024 * 1024)).toFixed(1) + ' / ' + (msg.total / (1024 * 1024)).toFixed(1) + ' MB';\n" +
+	"  }\n" +
+	"  fetch('/api/copy?progress=1', {\n" +
+	"    method: 'POST',\n" +
+	"    headers: { 'Content-Type': 'application/json' },\n" +
+	"    body: JSON.stringify({ share: currentShare, paths: list, target: target })\n" +
+	"  }).then(function(resp) {\n" +
+	"    if (!resp.ok) {\n" +
+	"      return resp.json().catch(function() { return {}; }).then(function(data) {\n" +
+	"        throw new Error(data.error || ('HTTP ' + resp.status));\n" +
+	"      });\n" +
+	"    }\n" +
+	"    return readNDJSON(resp, handleLine);\n" +
+	"  }).then(function() {\n" +
+	"    if (!result || !result.files) throw new Error('connection closed before the copy finished');\n" +
+	"    showBatchResult('COPIED', result);\n" +
+	"  }).catch(function(err) { fsUploadResult.textContent = 'Copy failed: ' + err.message; });\n" +
+	"}\n" +
+	"\n" +
+	"// 回收站视图：占用文件列表的位置，Back to files 回到原来的文件夹\n" +
+	"function trashPost(action, body) {\n" +
+	"  body.share = currentShare;\n" +
+	"  return fetch('/api/trash/' + action, {\n" +
+	"    method: 'POST',\n" +
+	"    headers: { 'Content-Type': 'application/json' },\n" +
+	"    body: JSON.stringify(body)\n" +
+	"  }).then(function(resp) {\n" +
+	"    return resp.json().catch(function() { return { error: 'HTTP ' + resp.status }; }).then(function(data) {\n" +
+	"      if (!data.files) throw new Error(data.error || ('HTTP ' + resp.status));\n" +
+	"      showUploadPanel();\n" +
+	"      fsUploadResult.textContent = data.files.map(function(f) {\n" +
+	"        if (f.status !== 'ok') return 'FAILED: ' + f.name + ' (' + f.error + ')';\n" +
+	"        return (action === 'restore' ? 'RESTORED: ' + f.path : 'REMOVED: ' + f.name);\n" +
+	"      }).join('\\n') || 'Trash is already empty.';\n" +
+	"      showTrash();\n" +
+	"    });\n" +
+	"  }).catch(function(err) { alert('Trash ' + action + ' failed: ' + err.message); });\n" +
+	"}\n" +
+	"\n" +
+	"function smallButton(text, color, onClick) {\n" +
+	"  var btn = document.createElement('button');\n" +
+	"  btn.textContent = text;\n" +
+	"  btn.style.cssText = 'margin-left:6px; padding:2px 8px; border-radius:999px; border:none; font-size:12px; cursor:pointer; color:white; background:' + color + ';';\n" +
+	"  btn.onclick = onClick;\n" +
+	"  return btn;\n" +
+	"}\n" +
+	"\n" +
+	"function showTrash() {\n" +
+	"  stopSearch();\n" +
+	"  fsSearchView.style.display = 'none';\n" +
+	"  fsList.style.display = 'none';\n" +
+	"  fsSelection.style.display = 'none';\n" +
+	"  fsTrashView.style.display = 'block';\n" +
+	"  fetch('/api/trash?' + shareParam()).then(function(resp) {\n" +
+	"    if (!resp.ok) { throw new Error('HTTP ' + resp.status); }\n" +
+	"    return resp.json();\n" +
+	"  }).then(function(data) {\n" +
+	"    fsTrashInfo.textContent = data.retentionDays > 0\n" +
+	"      ? 'Trash of ' + data.share + ': items are removed for good after ' + data.retentionDays + ' day(s).'\n" +
+	"      : 'Trash is disabled on this server, deletes are permanent.';\n" +
+	"    fsTrashList.innerHTML = '';\n" +
+	"    if (!data.items.length) {\n" +
+	"      var empty = document.createElement('li');\n" +
+	"      empty.textContent = 'Trash is empty.';\n" +
+	"      fsTrashList.appendChild(empty);\n" +
+	"      return;\n" +
 	"    }\n" +
+	"    data.items.forEach(function(item) {\n" +
+	"      var li = document.createElement('li');\n" +
+	"      li.style.cssText = 'margin:4px 0; font-size:14px; padding:4px 6px; border-radius:6px; background:#f9fafb;';\n" +
+	"      var name = document.createElement('span');\n" +
+	"      name.textContent = (item.isDir ? '[Dir] ' : '[File] ') + item.path;\n" +
+	"      li.appendChild(name);\n" +
+	"      var info = document.createElement('div');\n" +
+	"      info.style.cssText = 'font-size:12px; color:#6b7280;';\n" +
+	"      info.textContent = item.size + ' bytes, deleted ' + new Date(item.deleted).toLocaleString() +\n" +
+	"        ' by ' + item.client + ', removed after ' + new Date(item.expires).toLocaleString();\n" +
+	"      info.appendChild(smallButton('Restore', '#4f46e5', function() { trashPost('restore', { ids: [item.id] }); }));\n" +
+	"      info.appendChild(smallButton('Delete forever', '#dc2626', function() {\n" +
+	"        if (window.confirm('Delete ' + item.path + ' for good?')) trashPost('purge', { ids: [item.id] });\n" +
+	"      }));\n" +
+	"      li.appendChild(info);\n" +
+	"      fsTrashList.appendChild(li);\n" +
+	"    });\n" +
+	"  }).catch(function(err) { fsTrashInfo.textContent = 'Failed to load trash: ' + err.message; });\n" +
+	"}\n" +
+	"\n" +
+	"// 搜索：在当前文件夹下按名字找，结果边找边显示；再搜一次或者离开时取消上一次的\n" +
+	"var searchAbort = null;\n" +
+	"\n" +
+	"function stopSearch() {\n" +
+	"  if (searchAbort) searchAbort.abort();\n" +
+	"  searchAbort = null;\n" +
+	"}\n" +
+	"\n" +
+	"function addSearchResult(entry) {\n" +
+	"  var li = document.createElement('li');\n" +
+	"  li.style.cssText = 'margin:4px 0; font-size:14px; padding:4px 6px; border-radius:6px; background:#f9fafb;';\n" +
+	"  var name = document.createElement('span');\n" +
+	"  name.textContent = (entry.isDir ? '[Dir] ' : '[File] ') + entry.relPath;\n" +
+	"  name.title = entry.isDir ? 'Open this folder' : 'Download';\n" +
+	"  name.style.cursor = 'pointer';\n" +
+	"  name.onclick = function() {\n" +
+	"    if (entry.isDir) loadFsDir(entry.relPath);\n" +
+	"    else window.location = '/download?' + shareParam() + '&file=' + encodeURIComponent(entry.relPath);\n" +
 	"  };\n" +
-	"  xhr.onerror = function() { fsUploadResult.textContent = 'Upload error (network or server issue)'; };\n" +
+	"  li.appendChild(name);\n" +
+	"  var info = document.createElement('div');\n" +
+	"  info.style.cssText = 'font-size:12px; color:#6b7280;';\n" +
+	"  info.textContent = (entry.isDir ? '' : entry.size + ' bytes, ') + 'modified ' + new Date(entry.modTime).toLocaleString();\n" +
+	"  var parent = entry.relPath.split('/').slice(0, -1).join('/');\n" +
+	"  info.appendChild(smallButton('Show in folder', '#6b7280', function() { loadFsDir(parent); }));\n" +
+	"  li.appendChild(info);\n" +
+	"  if (entry.lines) li.appendChild(searchLinesBlock(entry));\n" +
+	"  fsSearchList.appendChild(li);\n" +
+	"}\n" +
 	"\n" +
-	"  xhr.send(formData);\n" +
+	"// 按内容搜索的匹配行：行号 + 内容，匹配的行加粗，中间隔开的地方用 ... 分开\n" +
+	"function searchLinesBlock(entry) {\n" +
+	"  var pre = document.createElement('pre');\n" +
+	"  pre.style.cssText = 'margin:4px 0 0; padding:4px 6px; font-size:12px; background:#ffffff; border:1px solid #e5e7eb; border-radius:6px; white-space:pre-wrap; word-break:break-all;';\n" +
+	"  var prev = 0;\n" +
+	"  entry.lines.forEach(function(l) {\n" +
+	"    if (prev && l.line !== prev + 1) pre.appendChild(document.createTextNode('...\\n'));\n" +
+	"    prev = l.line;\n" +
+	"    var row = document.createElement(l.match ? 'b' : 'span');\n" +
+	"    row.textContent = l.line + ': ' + l.text + '\\n';\n" +
+	"    pre.appendChild(row);\n" +
+	"  });\n" +
+	"  if (entry.moreLines) pre.appendChild(document.createTextNode('... (more matches not shown)\\n'));\n" +
+	"  if (entry.encoding === 'gbk') pre.title = 'Decoded as GBK';\n" +
+	"  return pre;\n" +
+	"}\n" +
+	"\n" +
+	"function runSearch() {\n" +
+	"  var q = fsSearchInput.value.trim();\n" +
+	"  if (!q) return;\n" +
+	"  var content = fsSearchMode.value === 'content';\n" +
+	"  stopSearch();\n" +
+	"  var ctrl = new AbortController();\n" +
+	"  searchAbort = ctrl;\n" +
+	"  fsList.style.display = 'none';\n" +
+	"  fsSelection.style.display = 'none';\n" +
+	"  fsTrashView.style.display = 'none';\n" +
+	"  fsSearchView.style.display = 'block';\n" +
+	"  fsSearchList.innerHTML = '';\n" +
+	"  var where = currentFsDir ? currentFsDir : 'the share root';\n" +
+	"  fsSearchInfo.textContent = 'Searching for \"' + q + '\" in ' + where + '...';\n" +
+	"  var url = '/api/search?' + shareParam() + '&dir=' + encodeURIComponent(currentFsDir) + '&q=' + encodeURIComponent(q);\n" +
+	"  if (content) url += '&mode=content';\n" +
+	"  else if (fsSearchType.value) url += '&type=' + fsSearchType.value;\n" +
+	"  fetch(url, { signal: ctrl.signal }).then(function(resp) {\n" +
+	"    if (!resp.ok) {\n" +
+	"      return resp.json().catch(function() { return {}; }).then(function(data) {\n" +
+	"        throw new Error(data.error || ('HTTP ' + resp.status));\n" +
+	"      });\n" +
+	"    }\n" +
+	"    return readNDJSON(resp, function(msg) {\n" +
+	"      if (msg.type === 'match') { addSearchResult(msg); return; }\n" +
+	"      if (msg.type !== 'done') return;\n" +
+	"      var text = msg.matches + ' match(es) for \"' + q + '\" in ' + where + ', ' + msg.scanned + ' item(s) looked at.';\n" +
+	"      if (content && msg.skipped) text += ' ' + msg.skipped + ' binary or large file(s) skipped.';\n" +
+	"      if (msg.truncated) text += ' Stopped at the result limit, try a narrower search.';\n" +
+	"      if (msg.timedOut) text += ' Took too long, only part of the folder was searched.';\n" +
+	"      fsSearchInfo.textContent = text;\n" +
+	"    });\n" +
+	"  }).catch(function(err) {\n" +
+	"    if (err.name !== 'AbortError') fsSearchInfo.textContent = 'Search failed: ' + err.message;\n" +
+	"  }).then(function() {\n" +
+	"    if (searchAbort === ctrl) searchAbort = null;\n" +
+	"  });\n" +
 	"}\n" +
 	"\n" +
 	"function looksLikeFile(name) {\n" +
@@ -603,18 +1367,55 @@ const pageTemplate = "" +
 	"  fetch('/api/create', {\n" +
 	"    method: 'POST',\n" +
 	"    headers: { 'Content-Type': 'application/json' },\n" +
-	"    body: JSON.stringify({ path: relPath, isDir: !isFile })\n" +
+	"    body: JSON.stringify({ share: currentShare, path: relPath, isDir: !isFile })\n" +
 	"  }).then(function(resp) {\n" +
-	"    if (!resp.ok) return resp.text().then(t => { throw new Error(t || ('HTTP ' + resp.status)); });\n" +
-	"    return resp.text();\n" +
-	"  }).then(function(msg) {\n" +
+	"    return resp.json().catch(function() { return { error: 'HTTP ' + resp.status }; }).then(function(data) {\n" +
+	"      if (!resp.ok) throw new Error(data.error || ('HTTP ' + resp.status));\n" +
+	"      return data;\n" +
+	"    });\n" +
+	"  }).then(function(data) {\n" +
 	"    showUploadPanel();\n" +
-	"    fsUploadResult.textContent = msg;\n" +
+	"    fsUploadResult.textContent = 'OK: created ' + (data.isDir ? 'folder ' : 'file ') + data.path;\n" +
 	"    loadFsDir(currentFsDir);\n" +
 	"  }).catch(function(err) { alert('Create failed: ' + err); });\n" +
 	"}\n" +
 	"\n" +
 	"if (fsNewBtn) fsNewBtn.addEventListener('click', function() { createItemInCurrentDir(); });\n" +
+	"if (fsHashBtn) fsHashBtn.addEventListener('click', function() { showSelectedChecksum(); });\n" +
+	"if (fsExtractBtn) fsExtractBtn.addEventListener('click', function() { extractSelected(); });\n" +
+	"if (fsDeleteBtn) fsDeleteBtn.addEventListener('click', function() { deleteSelected(); });\n" +
+	"if (fsRenameBtn) fsRenameBtn.addEventListener('click', function() { renameSelected(); });\n" +
+	"if (fsMoveBtn) fsMoveBtn.addEventListener('click', function() { moveSelected(); });\n" +
+	"if (fsCopyBtn) fsCopyBtn.addEventListener('click', function() { copySelected(); });\n" +
+	"document.getElementById('fsSearchBtn').addEventListener('click', function() { runSearch(); });\n" +
+	"fsSearchInput.addEventListener('keydown', function(e) { if (e.key === 'Enter') runSearch(); });\n" +
+	"fsSearchMode.addEventListener('change', function() {\n" +
+	"  var content = fsSearchMode.value === 'content';\n" +
+	"  fsSearchType.disabled = content;\n" +
+	"  fsSearchInput.placeholder = content ? 'Search text inside files in this folder' : 'Search names in this folder, e.g. report or *.jpg';\n" +
+	"});\n" +
+	"document.getElementById('fsSearchBackBtn').addEventListener('click', function() { loadFsDir(currentFsDir); });\n" +
+	"if (fsTrashBtn) fsTrashBtn.addEventListener('click', function() { showTrash(); });\n" +
+	"document.getElementById('fsTrashBackBtn').addEventListener('click', function() { loadFsDir(currentFsDir); });\n" +
+	"document.getElementById('fsTrashEmptyBtn').addEventListener('click', function() {\n" +
+	"  if (window.confirm('Remove everything in the trash for good?')) trashPost('purge', { all: true });\n" +
+	"});\n" +
+	"if (fsZipSelBtn) fsZipSelBtn.addEventListener('click', function() { downloadSelection(); });\n" +
+	"if (fsFormatSelect) {\n" +
+	"  try { fsFormatSelect.value = localStorage.getItem('ft-archive-format') || 'zip'; } catch (e) {}\n" +
+	"  if (!fsFormatSelect.value) fsFormatSelect.value = 'zip';\n" +
+	"  fsFormatSelect.addEventListener('change', function() {\n" +
+	"    try { localStorage.setItem('ft-archive-format', fsFormatSelect.value); } catch (e) {}\n" +
+	"    updateZipHref();\n" +
+	"  });\n" +
+	"}\n" +
+	"if (fsUploadDirBtn && fsUploadDirInput) {\n" +
+	"  fsUploadDirBtn.addEventListener('click', function() { fsUploadDirInput.click(); });\n" +
+	"  fsUploadDirInput.addEventListener('change', function() {\n" +
+	"    uploadToCurrentDir(fsUploadDirInput.files);\n" +
+	"    fsUploadDirInput.value = '';\n" +
+	"  });\n" +
+	"}\n" +
 	"if (fsUploadBtn && fsUploadInput) {\n" +
 	"  fsUploadBtn.addEventListener('click', function() { fsUploadInput.click(); });\n" +
 	"  fsUploadInput.addEventListener('change', function() {\n" +
@@ -623,6 +1424,62 @@ const pageTemplate = "" +
 	"  });\n" +
 	"}\n" +
 	"\n" +
+	"// 拖拽上传：文件和文件夹都可以，文件夹按目录结构上传\n" +
+	"function readAllEntries(reader) {\n" +
+	"  return new Promise(function(resolve, reject) {\n" +
+	"    var all = [];\n" +
+	"    (function more() {\n" +
+	"      reader.readEntries(function(batch) {\n" +
+	"        if (batch.length === 0) { resolve(all); return; }\n" +
+	"        all = all.concat(Array.prototype.slice.call(batch));\n" +
+	"        more();\n" +
+	"      }, reject);\n" +
+	"    })();\n" +
+	"  });\n" +
+	"}\n" +
+	"\n" +
+	"function collectEntry(entry, prefix, out) {\n" +
+	"  if (entry.isFile) {\n" +
+	"    return new Promise(function(resolve) {\n" +
+	"      entry.file(function(f) { out.push({ file: f, path: prefix + f.name }); resolve(); }, function() { resolve(); });\n" +
+	"    });\n" +
+	"  }\n" +
+	"  if (entry.isDirectory) {\n" +
+	"    return readAllEntries(entry.createReader()).then(function(children) {\n" +
+	"      return Promise.all(children.map(function(c) { return collectEntry(c, prefix + entry.name + '/', out); }));\n" +
+	"    });\n" +
+	"  }\n" +
+	"  return Promise.resolve();\n" +
+	"}\n" +
+	"\n" +
+	"var fsPanel = fsModal.firstElementChild;\n" +
+	"fsPanel.addEventListener('dragover', function(e) {\n" +
+	"  if (currentReadOnly) return;\n" +
+	"  e.preventDefault();\n" +
+	"  fsPanel.style.outline = '2px dashed #0ea5e9';\n" +
+	"});\n" +
+	"fsPanel.addEventListener('dragleave', function(e) { if (e.target === fsPanel) fsPanel.style.outline = ''; });\n" +
+	"fsPanel.addEventListener('drop', function(e) {\n" +
+	"  e.preventDefault();\n" +
+	"  fsPanel.style.outline = '';\n" +
+	"  if (currentReadOnly) return;\n" +
+	"  var dt = e.dataTransfer;\n" +
+	"  var entries = [];\n" +
+	"  if (dt.items) {\n" +
+	"    for (var i = 0; i < dt.items.length; i++) {\n" +
+	"      var en = dt.items[i].webkitGetAsEntry ? dt.items[i].webkitGetAsEntry() : null;\n" +
+	"      if (en) entries.push(en);\n" +
+	"    }\n" +
+	"  }\n" +
+	"  if (entries.length === 0) { uploadToCurrentDir(dt.files); return; }\n" +
+	"  var out = [];\n" +
+	"  Promise.all(entries.map(function(en) { return collectEntry(en, '', out); })).then(function() { uploadItems(out); });\n" +
+	"});\n" +
+	"\n" +
+	"if (fsShareSelect) fsShareSelect.addEventListener('change', function() {\n" +
+	"  currentShare = fsShareSelect.value;\n" +
+	"  openBrowserForFolder('');\n" +
+	"});\n" +
 	"if (fsCloseBtn) fsCloseBtn.addEventListener('click', function() { closeFsModal(); });\n" +
 	"fsModal.addEventListener('click', function(e) { if (e.target === fsModal) closeFsModal(); });\n" +
 	"if (fsUpBtn) fsUpBtn.addEventListener('click', function() {\n" +
@@ -632,11 +1489,27 @@ const pageTemplate = "" +
 	"});\n" +
 	"\n" +
 	"var manageBtn = document.getElementById('manageBtn');\n" +
-	"if (manageBtn) manageBtn.addEventListener('click', function() { openBrowserForFolder(''); });\n" +
+	"if (manageBtn) manageBtn.addEventListener('click', function() { loadShares(); openBrowserForFolder(''); });\n" +
 	"</script>\n" +
 	"</body>\n" +
 	"</html>\n"
 
+// 首页上列出每个共享对应的本机目录
+func renderShareList() string {
+	var b strings.Builder
+	for _, sh := range shares {
+		b.WriteString("<div>")
+		b.WriteString(html.EscapeString(sh.Name))
+		b.WriteString(": ")
+		b.WriteString(html.EscapeString(sh.Path))
+		if sh.ReadOnly {
+			b.WriteString(" (read-only)")
+		}
+		b.WriteString("</div>")
+	}
+	return b.String()
+}
+
 func renderLogin(w http.ResponseWriter, showError bool) {
 	errHTML := ""
 	if showError {
@@ -647,6 +1520,11 @@ func renderLogin(w http.ResponseWriter, showError bool) {
 	_, _ = w.Write([]byte(page))
 }
 
+// 程序自己的隐藏目录和上传临时文件，不出现在列表和压缩包里，也不能通过接口访问
+func isInternalName(name string) bool {
+	return name == metaDirName || strings.HasPrefix(name, tempFilePrefix)
+}
+
 // 安全拼路径 + 检查不能逃出 root
 func joinSafe(root, rel string) (string, error) {
 	rel = strings.ReplaceAll(rel, "\\", "/")
@@ -654,13 +1532,19 @@ func joinSafe(root, rel string) (string, error) {
 	if rel == "" || rel == "." {
 		return root, nil
 	}
-	if strings.Contains(rel, "..") {
-		return "", fmt.Errorf("invalid path")
+	// 只拒绝整段是 ".." 的，a..b.txt 这样的名字是合法的
+	for _, part := range strings.Split(rel, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("invalid path")
+		}
+		if isInternalName(part) {
+			return "", fmt.Errorf("reserved path")
+		}
 	}
 	full := filepath.Join(root, rel)
 	rootAbs, _ := filepath.Abs(root)
 	fullAbs, _ := filepath.Abs(full)
-	if !strings.HasPrefix(fullAbs, rootAbs) {
+	if r, err := filepath.Rel(rootAbs, fullAbs); err != nil || r == ".." || strings.HasPrefix(r, ".."+string(filepath.Separator)) {
 		return "", fmt.Errorf("out of root")
 	}
 	return fullAbs, nil
@@ -675,24 +1559,46 @@ type listEntry struct {
 }
 
 type listResponse struct {
+	Share       string      `json:"share"`
+	ReadOnly    bool        `json:"readOnly"`
 	Dir         string      `json:"dir"`
 	DisplayPath string      `json:"displayPath"`
 	Entries     []listEntry `json:"entries"`
 }
 
 type createRequest struct {
-	Path  string `json:"path"`  // relative to root
+	Share string `json:"share"` // share name, empty = default share
+	Path  string `json:"path"`  // relative to share root
 	IsDir bool   `json:"isDir"` // true=folder, false=file
 }
 
 func main() {
-	desktop := getDesktop()
-	root := filepath.Join(desktop, "Myfiles")
-	_ = os.MkdirAll(root, 0755)
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		if err := runInit(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
 
-	reader := bufio.NewReader(os.Stdin)
-	port := choosePort(reader)
-	authPassword = choosePassword(reader)
+	opts, err := loadOptions(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	shares = opts.shares
+	for _, sh := range shares {
+		if err := ensureShareDir(sh); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	port := opts.port
+	authPassword = opts.password
 	serverToken = generateServerToken()
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -700,7 +1606,7 @@ func main() {
 			renderLogin(w, false)
 			return
 		}
-		page := strings.ReplaceAll(pageTemplate, "__ROOT__", html.EscapeString(root))
+		page := strings.ReplaceAll(pageTemplate, "__ROOT__", renderShareList())
 		w.Header().Set("Content-Type", "text/html; charset=utf-8")
 		_, _ = w.Write([]byte(page))
 	})
@@ -725,7 +1631,7 @@ func main() {
 
 	http.HandleFunc("/api/create", func(w http.ResponseWriter, r *http.Request) {
 		if !isAuthed(r) {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			writeAPIError(w, r, codeUnauthorized, "unauthorized")
 			return
 		}
 		if r.Method != http.MethodPost {
@@ -734,105 +1640,82 @@ func main() {
 		}
 		var req createRequest
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-			http.Error(w, "bad json", http.StatusBadRequest)
+			writeAPIError(w, r, codeBadRequest, "bad json")
 			return
 		}
 		req.Path = strings.TrimSpace(req.Path)
 		if req.Path == "" {
-			http.Error(w, "empty path", http.StatusBadRequest)
+			writeAPIError(w, r, codeInvalidName, "empty path")
+			return
+		}
+		sh, err := findShare(req.Share)
+		if err != nil {
+			writeAPIError(w, r, codeNotFound, err.Error())
 			return
 		}
-		full, err := joinSafe(root, req.Path)
+		if sh.ReadOnly {
+			writeAPIError(w, r, codeReadOnly, "share "+sh.Name+" is read-only")
+			return
+		}
+		full, err := joinSafe(sh.Path, req.Path)
 		if err != nil {
-			http.Error(w, "invalid path", http.StatusBadRequest)
+			writeAPIError(w, r, codeInvalidName, "invalid path")
 			return
 		}
+		resp := createResponse{Share: sh.Name, Path: filepath.ToSlash(filepath.Clean(req.Path)), IsDir: req.IsDir}
 
 		if req.IsDir {
 			if err := os.MkdirAll(full, 0755); err != nil {
-				http.Error(w, "mkdir failed: "+err.Error(), http.StatusInternalServerError)
+				writeAPIError(w, r, codeIO, "mkdir failed: "+err.Error())
 				return
 			}
-			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-			fmt.Fprintf(w, "OK: created folder -> %s", full)
+			if wantsText(r) {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+				w.WriteHeader(http.StatusCreated)
+				fmt.Fprintf(w, "OK: created folder -> %s", full)
+				return
+			}
+			writeJSON(w, http.StatusCreated, resp)
 			return
 		}
 
 		parent := filepath.Dir(full)
 		if err := os.MkdirAll(parent, 0755); err != nil {
-			http.Error(w, "ensure parent failed: "+err.Error(), http.StatusInternalServerError)
+			writeAPIError(w, r, codeIO, "ensure parent failed: "+err.Error())
 			return
 		}
-		if _, err := os.Stat(full); err == nil {
-			http.Error(w, "file already exists", http.StatusConflict)
+		f, err := os.OpenFile(full, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			writeAPIError(w, r, codeExists, "file already exists")
 			return
 		}
-		f, err := os.Create(full)
 		if err != nil {
-			http.Error(w, "create file failed: "+err.Error(), http.StatusInternalServerError)
+			writeAPIError(w, r, codeIO, "create file failed: "+err.Error())
 			return
 		}
 		_ = f.Close()
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		fmt.Fprintf(w, "OK: created file -> %s", full)
-	})
-
-	http.HandleFunc("/upload", func(w http.ResponseWriter, r *http.Request) {
-		if !isAuthed(r) {
-			http.Error(w, "unauthorized", http.StatusUnauthorized)
-			return
-		}
-		if err := r.ParseMultipartForm(64 << 20); err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-
-		targetRel := strings.TrimSpace(r.FormValue("target"))
-		fullDir, err := joinSafe(root, targetRel)
-		if err != nil {
-			http.Error(w, "invalid target dir", http.StatusBadRequest)
-			return
-		}
-		if err := os.MkdirAll(fullDir, 0755); err != nil {
-			http.Error(w, "failed to ensure target dir: "+err.Error(), http.StatusInternalServerError)
-			return
-		}
-
-		files := r.MultipartForm.File["files"]
-		if len(files) == 0 {
-			http.Error(w, "no files uploaded", http.StatusBadRequest)
+		if wantsText(r) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, "OK: created file -> %s", full)
 			return
 		}
+		writeJSON(w, http.StatusCreated, resp)
+	})
 
-		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-		fmt.Fprintf(w, "Target directory:\n%s\n\n", fullDir)
-		fmt.Fprintf(w, "Received %d file(s):\n\n", len(files))
-
-		for _, header := range files {
-			src, err := header.Open()
-			if err != nil {
-				fmt.Fprintf(w, "FAILED: %s (%v)\n", header.Filename, err)
-				continue
-			}
-			dstPath := filepath.Join(fullDir, filepath.Base(header.Filename))
-			dst, err := os.Create(dstPath)
-			if err != nil {
-				fmt.Fprintf(w, "FAILED: %s (%v)\n", header.Filename, err)
-				_ = src.Close()
-				continue
-			}
-
-			_, err = io.Copy(dst, src)
-			_ = src.Close()
-			_ = dst.Close()
+	http.HandleFunc("/upload", handleUpload)
+	http.HandleFunc("/api/exists", handleExists)
+	http.HandleFunc("/api/hash", handleHash)
+	http.HandleFunc("/api/extract", handleExtract)
+	http.HandleFunc("/api/delete", handleDelete)
+	http.HandleFunc("/api/move", handleMove)
+	http.HandleFunc("/api/copy", handleCopy)
+	http.HandleFunc("/api/search", handleSearch)
+	http.HandleFunc("/api/trash", handleTrash)
+	http.HandleFunc("/api/trash/restore", handleTrashRestore)
+	http.HandleFunc("/api/trash/purge", handleTrashPurge)
 
-			if err != nil {
-				fmt.Fprintf(w, "FAILED: %s (%v)\n", header.Filename, err)
-				continue
-			}
-			fmt.Fprintf(w, "OK: %s -> %s\n", header.Filename, dstPath)
-		}
-	})
+	http.HandleFunc(tusPathPrefix, handleTus)
 
 	http.HandleFunc("/api/list", func(w http.ResponseWriter, r *http.Request) {
 		if !isAuthed(r) {
@@ -841,7 +1724,11 @@ func main() {
 		}
 
 		rel := strings.TrimSpace(r.URL.Query().Get("dir"))
-		full, err := joinSafe(root, rel)
+		sh, ok := shareFromRequest(w, r)
+		if !ok {
+			return
+		}
+		full, err := joinSafe(sh.Path, rel)
 		if err != nil {
 			http.Error(w, "invalid dir", http.StatusBadRequest)
 			return
@@ -860,6 +1747,8 @@ func main() {
 		}
 
 		resp := listResponse{
+			Share:       sh.Name,
+			ReadOnly:    sh.ReadOnly,
 			Dir:         filepath.ToSlash(rel),
 			DisplayPath: full,
 		}
@@ -870,6 +1759,9 @@ func main() {
 				continue
 			}
 			name := e.Name()
+			if isInternalName(name) {
+				continue
+			}
 			relPath := name
 			if rel != "" {
 				relPath = filepath.Join(rel, name)
@@ -889,6 +1781,15 @@ func main() {
 		_ = json.NewEncoder(w).Encode(resp)
 	})
 
+	http.HandleFunc("/api/shares", func(w http.ResponseWriter, r *http.Request) {
+		if !isAuthed(r) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		_ = json.NewEncoder(w).Encode(shareInfos())
+	})
+
 	http.HandleFunc("/download", func(w http.ResponseWriter, r *http.Request) {
 		if !isAuthed(r) {
 			http.Error(w, "unauthorized", http.StatusUnauthorized)
@@ -896,7 +1797,11 @@ func main() {
 		}
 
 		rel := strings.TrimSpace(r.URL.Query().Get("file"))
-		full, err := joinSafe(root, rel)
+		sh, ok := shareFromRequest(w, r)
+		if !ok {
+			return
+		}
+		full, err := joinSafe(sh.Path, rel)
 		if err != nil {
 			http.Error(w, "invalid file", http.StatusBadRequest)
 			return
@@ -924,7 +1829,11 @@ func main() {
 		}
 
 		rel := strings.TrimSpace(r.URL.Query().Get("dir"))
-		full, err := joinSafe(root, rel)
+		sh, ok := shareFromRequest(w, r)
+		if !ok {
+			return
+		}
+		full, err := joinSafe(sh.Path, rel)
 		if err != nil {
 			http.Error(w, "invalid dir", http.StatusBadRequest)
 			return
@@ -940,40 +1849,42 @@ func main() {
 		if baseName == "" || baseName == "." {
 			baseName = "root"
 		}
-		w.Header().Set("Content-Type", "application/zip")
-		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, baseName))
-
-		zw := zip.NewWriter(w)
-		defer zw.Close()
-
-		_ = filepath.WalkDir(full, func(path string, d fs.DirEntry, err error) error {
-			if err != nil {
-				return nil
-			}
-			if d.IsDir() {
-				return nil
-			}
-			relInside, err := filepath.Rel(full, path)
-			if err != nil {
-				return nil
-			}
-			zipPath := filepath.ToSlash(relInside)
-
-			fw, err := zw.Create(zipPath)
-			if err != nil {
-				return nil
-			}
-			f, err := os.Open(path)
-			if err != nil {
-				return nil
-			}
-			_, _ = io.Copy(fw, f)
-			_ = f.Close()
-			return nil
-		})
+		format, err := parseArchiveFormat(r.URL.Query().Get("format"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		c := &archiveCollector{keepLinks: format != formatZip, strict: parseBoolParam(r.URL.Query().Get("strict"))}
+		c.add(full, "")
+		level, err := parseCompressionLevel(r.URL.Query().Get("level"), r.URL.Query().Get("mode"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		serveArchive(w, r, c, archiveFilename(baseName, format), format, level)
 	})
+	http.HandleFunc("/download-selection", handleDownloadSelection)
 
-	fmt.Println("Root folder:", root)
+	maxUploadSize = opts.maxUploadSize
+	defaultConflictPolicy = opts.onConflict
+	if opts.zipWorkers > 0 {
+		zipWorkers = opts.zipWorkers
+	}
+	trashRetention = time.Duration(opts.trashDays) * 24 * time.Hour
+	startTusJanitor()
+	startTrashJanitor()
+	go cleanupTempFiles(time.Now())
+
+	for _, sh := range shares {
+		mode := ""
+		if sh.ReadOnly {
+			mode = " (只读)"
+		}
+		fmt.Printf("Share %s: %s%s\n", sh.Name, sh.Path, mode)
+	}
 	fmt.Println("密码已设置，打开浏览器访问: http://<本机的IP>:" + port)
-	_ = http.ListenAndServe(":"+port, nil)
+	if err := http.ListenAndServe(":"+port, nil); err != nil {
+		fmt.Fprintln(os.Stderr, "启动失败:", err)
+		os.Exit(1)
+	}
 }
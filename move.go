@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+type moveRequest struct {
+	Share    string   `json:"share"`
+	Paths    []string `json:"paths"`    // 要移动的项目，相对共享根目录
+	Target   string   `json:"target"`   // 目标文件夹，空 = 共享根目录；只改名时不用填
+	ToShare  string   `json:"toShare"`  // 目标共享，空 = 同一个共享
+	Name     string   `json:"name"`     // 改名：新名字，只能有一个 path，不带 target 就是原地改名
+	Conflict string   `json:"conflict"` // 目标已经有同名的怎么办，同 /upload
+}
+
+type moveResponse struct {
+	Share   string `json:"share"`
+	ToShare string `json:"toShare"`
+	Target  string `json:"target"`
+	resultList
+}
+
+// 一次移动 / 复制的源和目标，路径都已经过 joinSafe
+type transferItem struct {
+	rel  string // 请求里的源路径
+	src  string
+	dst  string
+	dsh  *share
+	info fs.FileInfo
+}
+
+// /api/move：改名，或者把一个 / 多个项目挪到另一个文件夹（可以是另一个共享）。
+// 同一个文件系统里就是 rename；跨文件系统时复制过去再删掉原来的
+func handleMove(w http.ResponseWriter, r *http.Request) {
+	if !isAuthed(r) {
+		writeAPIError(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req moveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, r, codeBadRequest, "bad json")
+		return
+	}
+	resp, policy, ok := startTransfer(w, r, req, true)
+	if !ok {
+		return
+	}
+	for _, rel := range req.Paths {
+		item, res := resolveTransfer(req, rel)
+		if res.Status == "" {
+			for _, res := range moveItem(r.Context(), item, policy) {
+				resp.add(res)
+			}
+			continue
+		}
+		resp.add(res)
+	}
+	writeJSON(w, resp.status(), resp)
+}
+
+// 移动和复制共用的请求检查；目标共享要可写，移动时源共享也要可写
+func startTransfer(w http.ResponseWriter, r *http.Request, req moveRequest, srcWritable bool) (*moveResponse, conflictPolicy, bool) {
+	if len(req.Paths) == 0 {
+		writeAPIError(w, r, codeBadRequest, "no paths")
+		return nil, "", false
+	}
+	if req.Name != "" && len(req.Paths) != 1 {
+		writeAPIError(w, r, codeBadRequest, "name needs exactly one path")
+		return nil, "", false
+	}
+	policy, err := parseConflictPolicy(req.Conflict)
+	if err != nil {
+		writeAPIError(w, r, codeBadRequest, err.Error())
+		return nil, "", false
+	}
+	sh, err := findShare(req.Share)
+	if err != nil {
+		writeAPIError(w, r, codeNotFound, err.Error())
+		return nil, "", false
+	}
+	dsh := sh
+	if req.ToShare != "" {
+		if dsh, err = findShare(req.ToShare); err != nil {
+			writeAPIError(w, r, codeNotFound, err.Error())
+			return nil, "", false
+		}
+	}
+	for _, s := range []*share{dsh, sh} {
+		if s.ReadOnly && (s == dsh || srcWritable) {
+			writeAPIError(w, r, codeReadOnly, "share "+s.Name+" is read-only")
+			return nil, "", false
+		}
+	}
+	resp := &moveResponse{Share: sh.Name, ToShare: dsh.Name, Target: transferTarget(req), resultList: resultList{Files: []fileResult{}}}
+	return resp, policy, true
+}
+
+// 目标文件夹：改名且没给 target 时就是原来所在的文件夹
+func transferTarget(req moveRequest) string {
+	target := strings.TrimSpace(req.Target)
+	if target == "" && req.Name != "" && req.ToShare == "" {
+		target = path.Dir(filepath.ToSlash(strings.TrimSpace(req.Paths[0])))
+	}
+	if target == "." {
+		target = ""
+	}
+	return target
+}
+
+// 检查一项的源和目标；不合法时返回的 fileResult 已经填好失败原因，否则 Status 为空
+func resolveTransfer(req moveRequest, rel string) (transferItem, fileResult) {
+	res := fileResult{Name: rel}
+	fail := func(code, msg string) (transferItem, fileResult) {
+		res.Status, res.Code, res.Error = resultFailed, code, msg
+		return transferItem{}, res
+	}
+	sh, _ := findShare(req.Share)
+	dsh := sh
+	if req.ToShare != "" {
+		dsh, _ = findShare(req.ToShare)
+	}
+	src, err := joinSafe(sh.Path, rel)
+	if err != nil {
+		return fail(codeInvalidName, "invalid path")
+	}
+	if relPath, err := filepath.Rel(sh.Path, src); err != nil || relPath == "." {
+		return fail(codeInvalidName, "cannot move or copy the share root")
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return fail(codeNotFound, "not found")
+	}
+	name := filepath.Base(src)
+	if req.Name != "" {
+		name = strings.TrimSpace(req.Name)
+		if name == "" || name == "." || strings.ContainsAny(name, `/\`) {
+			return fail(codeInvalidName, "invalid new name")
+		}
+	}
+	target := transferTarget(req)
+	dstDir, err := joinSafe(dsh.Path, target)
+	if err != nil {
+		return fail(codeInvalidName, "invalid target folder")
+	}
+	if st, err := os.Stat(dstDir); err != nil || !st.IsDir() {
+		return fail(codeNotFound, "target folder not found")
+	}
+	dst, err := joinSafe(dsh.Path, path.Join(filepath.ToSlash(target), name))
+	if err != nil {
+		return fail(codeInvalidName, "invalid new name")
+	}
+	if info.IsDir() && strings.HasPrefix(dst, src+string(filepath.Separator)) {
+		return fail(codeInvalidName, "cannot move or copy a folder into itself")
+	}
+	return transferItem{rel: rel, src: src, dst: dst, dsh: dsh, info: info}, res
+}
+
+// 第一条是这一项本身的结果，后面是跨文件系统时留在原处的特殊文件
+func moveItem(ctx context.Context, item transferItem, policy conflictPolicy) []fileResult {
+	res := fileResult{Name: item.rel, Status: resultFailed}
+	if !item.info.IsDir() {
+		res.Size = item.info.Size()
+	}
+	var finalPath, outcome string
+	var skipped []string
+	var err error
+	if st, serr := os.Lstat(item.dst); serr == nil && strings.EqualFold(item.src, item.dst) && os.SameFile(st, item.info) {
+		// 原地不动，或者不区分大小写的文件系统上只改大小写
+		if item.src == item.dst {
+			res.Status, res.Path = resultSkipped, relTo(item.dsh, item.dst)
+			res.Code, res.Error = codeExists, "already there"
+			return []fileResult{res}
+		}
+		finalPath, outcome, err = item.dst, outcomeRenamed, os.Rename(item.src, item.dst)
+	} else {
+		finalPath, outcome, err = moveIntoPlace(item.src, item.dst, policy)
+		if isCrossDevice(err) {
+			finalPath, outcome, skipped, err = moveAcrossDevices(ctx, item, policy)
+		}
+	}
+	switch {
+	case errors.Is(err, errSkippedFile):
+		res.Status, res.Code, res.Error = resultSkipped, codeExists, "already exists"
+		return []fileResult{res}
+	case err != nil:
+		res.Code, res.Error = uploadErrorCode(err), err.Error()
+		if errors.Is(err, errSpecialFile) {
+			res.Code = codeUnsupported
+		}
+		return []fileResult{res}
+	}
+	res.Status, res.Outcome, res.Path = resultOK, outcome, relTo(item.dsh, finalPath)
+	fmt.Printf("已移动: %s -> %s\n", item.src, finalPath)
+	return append([]fileResult{res}, skippedResults(item, skipped, "not a regular file, left in place")...)
+}
+
+// 复制过去（见 copyIntoPlace）成功之后再删掉源；中途失败的话源还在。
+// 跳过的特殊文件没有复制过去，留在原处，连同它们所在的文件夹
+func moveAcrossDevices(ctx context.Context, item transferItem, policy conflictPolicy) (string, string, []string, error) {
+	finalPath, outcome, skipped, err := copyIntoPlace(ctx, item.src, item.dst, policy, nil)
+	if err != nil {
+		return "", "", nil, err
+	}
+	if err := removeAllExcept(item.src, skipped); err != nil {
+		return "", "", nil, fmt.Errorf("copied to %s but could not remove the source: %w", relTo(item.dsh, finalPath), err)
+	}
+	return finalPath, outcome, skipped, nil
+}
+
+// 同 os.RemoveAll，但 keep 里的路径和它们的上级文件夹留着
+func removeAllExcept(root string, keep []string) error {
+	if len(keep) == 0 {
+		return os.RemoveAll(root)
+	}
+	kept := map[string]bool{}
+	for _, p := range keep {
+		for ; p != root && !kept[p]; p = filepath.Dir(p) {
+			kept[p] = true
+		}
+		kept[root] = true
+	}
+	var paths []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !kept[p] {
+			paths = append(paths, p)
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dst 旁边一个还不存在的隐藏临时名字，列表里看不到，异常退出后启动时会清掉
+func tempSibling(dst string) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(dst), tempFilePrefix+"*")
+	if err != nil {
+		return "", err
+	}
+	name := f.Name()
+	_ = f.Close()
+	if err := os.Remove(name); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+func isCrossDevice(err error) bool {
+	if errors.Is(err, syscall.EXDEV) {
+		return true
+	}
+	// Windows 的 ERROR_NOT_SAME_DEVICE
+	return runtime.GOOS == "windows" && errors.Is(err, syscall.Errno(17))
+}
+
+func relTo(sh *share, full string) string {
+	rel, err := filepath.Rel(sh.Path, full)
+	if err != nil {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
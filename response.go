@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// /upload 和 /api/create 默认返回 JSON；?format=text 或者 Accept: text/plain 时
+// 还是原来一行一个结果的文本，方便 curl 直接看
+
+// 单个文件的结果
+const (
+	resultOK      = "ok"
+	resultSkipped = "skipped"
+	resultFailed  = "failed"
+)
+
+// 错误码，脚本按这个判断，不用去匹配错误信息
+const (
+	codeBadRequest       = "bad_request"
+	codeUnauthorized     = "unauthorized"
+	codeNotFound         = "not_found"
+	codeReadOnly         = "read_only"
+	codeInvalidName      = "invalid_name"
+	codeInvalidChecksum  = "invalid_checksum"
+	codeChecksumMismatch = "checksum_mismatch"
+	codeExists           = "exists"
+	codeTooLarge         = "too_large"
+	codeFieldOrder       = "field_order"
+	codeUnsupported      = "unsupported"
+	codeNotEmpty         = "not_empty"
+	codeIO               = "io_error"
+)
+
+type apiError struct {
+	Code  string `json:"code"`
+	Error string `json:"error"`
+}
+
+type fileResult struct {
+	Name    string `json:"name"`           // 客户端给的文件名（可以带相对路径）
+	Path    string `json:"path,omitempty"` // 最终位置，相对共享根目录
+	Size    int64  `json:"size"`
+	Status  string `json:"status"`
+	Outcome string `json:"outcome,omitempty"` // created / overwritten / renamed / trashed / deleted
+	SHA256  string `json:"sha256,omitempty"`
+	Code    string `json:"code,omitempty"`
+	Error   string `json:"error,omitempty"`
+
+	full string // 绝对路径，只在文本模式里显示
+}
+
+// 一次处理多个文件的结果，上传、解压、删除等都用
+type resultList struct {
+	Files     []fileResult `json:"files"`
+	Errors    []apiError   `json:"errors,omitempty"` // 不属于某个文件的问题，例如请求体读到一半断了
+	Succeeded int          `json:"succeeded"`
+	Skipped   int          `json:"skipped"`
+	Failed    int          `json:"failed"`
+}
+
+type uploadResponse struct {
+	Share  string `json:"share"`
+	Target string `json:"target"`
+	resultList
+
+	fullDir string
+}
+
+type createResponse struct {
+	Share string `json:"share"`
+	Path  string `json:"path"`
+	IsDir bool   `json:"isDir"`
+}
+
+func wantsText(r *http.Request) bool {
+	switch strings.ToLower(r.URL.Query().Get("format")) {
+	case "text":
+		return true
+	case "json":
+		return false
+	}
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "application/json")
+}
+
+func codeStatus(code string) int {
+	switch code {
+	case codeUnauthorized:
+		return http.StatusUnauthorized
+	case codeReadOnly:
+		return http.StatusForbidden
+	case codeNotFound:
+		return http.StatusNotFound
+	case codeExists, codeNotEmpty:
+		return http.StatusConflict
+	case codeTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case codeChecksumMismatch:
+		return http.StatusUnprocessableEntity
+	case codeIO:
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadRequest
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// 整个请求失败时用，状态码按错误码来
+func writeAPIError(w http.ResponseWriter, r *http.Request, code, msg string) {
+	if wantsText(r) {
+		http.Error(w, msg, codeStatus(code))
+		return
+	}
+	writeJSON(w, codeStatus(code), apiError{Code: code, Error: msg})
+}
+
+func uploadErrorCode(err error) string {
+	var tooLarge *http.MaxBytesError
+	switch {
+	case errors.As(err, &tooLarge):
+		return codeTooLarge
+	case errors.Is(err, errFileExists), errors.Is(err, errSkippedFile), errors.Is(err, errFolderExists):
+		return codeExists
+	case errors.Is(err, errChecksumMismatch):
+		return codeChecksumMismatch
+	}
+	return codeIO
+}
+
+func (resp *resultList) add(res fileResult) {
+	switch res.Status {
+	case resultOK:
+		resp.Succeeded++
+	case resultSkipped:
+		resp.Skipped++
+	default:
+		resp.Failed++
+	}
+	resp.Files = append(resp.Files, res)
+}
+
+// 全部成功（跳过也算）200；有成有败 207；一个都没成功就用第一个错误的状态码
+func (resp *resultList) status() int {
+	if resp.Failed == 0 && len(resp.Errors) == 0 {
+		return http.StatusOK
+	}
+	if resp.Succeeded+resp.Skipped > 0 {
+		return http.StatusMultiStatus
+	}
+	for _, f := range resp.Files {
+		if f.Status == resultFailed {
+			return codeStatus(f.Code)
+		}
+	}
+	return codeStatus(resp.Errors[0].Code)
+}
+
+func writeUploadResponse(w http.ResponseWriter, r *http.Request, resp *uploadResponse) {
+	if !wantsText(r) {
+		writeJSON(w, resp.status(), resp)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(resp.status())
+	fmt.Fprintf(w, "Target directory:\n%s\n\n", resp.fullDir)
+	for _, f := range resp.Files {
+		switch f.Status {
+		case resultOK:
+			note := ""
+			if f.Outcome != outcomeCreated {
+				note = " (" + f.Outcome + ")"
+			}
+			fmt.Fprintf(w, "OK: %s -> %s%s sha256=%s\n", f.Name, f.full, note, f.SHA256)
+		case resultSkipped:
+			fmt.Fprintf(w, "SKIPPED: %s (already exists)\n", f.Name)
+		default:
+			fmt.Fprintf(w, "FAILED: %s (%s)\n", f.Name, f.Error)
+		}
+	}
+	for _, e := range resp.Errors {
+		fmt.Fprintf(w, "FAILED: %s\n", e.Error)
+	}
+	fmt.Fprintf(w, "\nReceived %d file(s).\n", len(resp.Files))
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 一次搜索最多返回多少条、最多花多久，到了就停下，最后一行会说明
+const (
+	searchDefaultLimit   = 1000
+	searchMaxLimit       = 10000
+	searchDefaultTimeout = 15 * time.Second
+	searchMaxTimeout     = 60 * time.Second
+)
+
+// 一边找一边返回，一行一个 JSON（application/x-ndjson），最后一行是 done
+type searchMatch struct {
+	Type string `json:"type"` // match
+	listEntry
+	Encoding  string       `json:"encoding,omitempty"` // 以下是按内容搜索时才有的：utf-8 / gbk / unknown
+	Lines     []searchLine `json:"lines,omitempty"`
+	MoreLines bool         `json:"moreLines,omitempty"` // 匹配行太多，后面的没返回
+}
+
+type searchDone struct {
+	Type      string `json:"type"` // done
+	Matches   int    `json:"matches"`
+	Scanned   int    `json:"scanned"`   // 看过的文件和文件夹数
+	Skipped   int    `json:"skipped"`   // 按内容搜索时没读的文件：二进制、太大、读不了
+	Truncated bool   `json:"truncated"` // 到了 limit
+	TimedOut  bool   `json:"timedOut"`
+}
+
+type searchFilter struct {
+	pattern string // 已经转成小写；按内容搜索时可以为空，表示不限名字
+	glob    bool   // 带 * ? [ 时按通配符匹配整个名字，否则名字里包含就算
+	kind    string // 空 / file / dir
+	minSize int64
+	maxSize int64 // 0 不限
+	after   time.Time
+	before  time.Time
+
+	content     bool   // mode=content
+	text        string // 要找的内容，已经转成小写
+	context     int    // 匹配行前后各带几行
+	maxFileSize int64
+}
+
+// mode=name（默认）时 q 是名字；mode=content 时 q 是要找的内容，名字用 name 过滤
+func parseSearchFilter(q url.Values) (*searchFilter, error) {
+	f := &searchFilter{}
+	switch mode := strings.ToLower(q.Get("mode")); mode {
+	case "", "name":
+		f.pattern = strings.ToLower(strings.TrimSpace(q.Get("q")))
+		if f.pattern == "" {
+			return nil, errors.New("missing q")
+		}
+	case "content":
+		f.content = true
+		f.text = strings.ToLower(strings.TrimSpace(q.Get("q")))
+		if f.text == "" {
+			return nil, errors.New("missing q")
+		}
+		f.pattern = strings.ToLower(strings.TrimSpace(q.Get("name")))
+	default:
+		return nil, errors.New("mode must be name or content")
+	}
+	if strings.ContainsAny(f.pattern, `/\`) {
+		return nil, errors.New("names cannot contain / or \\, use dir to pick the folder")
+	}
+	f.glob = strings.ContainsAny(f.pattern, "*?[")
+	if _, err := path.Match(f.pattern, ""); f.glob && err != nil {
+		return nil, errors.New("invalid pattern in q")
+	}
+	switch f.kind = strings.ToLower(q.Get("type")); f.kind {
+	case "", "file", "dir":
+	default:
+		return nil, errors.New("type must be file or dir")
+	}
+	var err error
+	if f.minSize, err = parseSize(q.Get("minSize")); err != nil {
+		return nil, err
+	}
+	if f.maxSize, err = parseSize(q.Get("maxSize")); err != nil {
+		return nil, err
+	}
+	if f.after, err = parseSearchTime(q.Get("after")); err != nil {
+		return nil, err
+	}
+	if f.before, err = parseSearchTime(q.Get("before")); err != nil {
+		return nil, err
+	}
+	if !f.content {
+		return f, nil
+	}
+	f.kind = "file"
+	if f.context, err = queryInt(q, "context", searchDefaultContext, 0, searchMaxContext); err != nil {
+		return nil, err
+	}
+	if f.maxFileSize, err = parseSize(q.Get("maxFileSize")); err != nil {
+		return nil, err
+	}
+	if f.maxFileSize == 0 {
+		f.maxFileSize = searchDefaultMaxFileSize
+	}
+	f.maxFileSize = min(f.maxFileSize, searchMaxFileSizeCap)
+	return f, nil
+}
+
+// 2024-05-01（本地时间当天 0 点）或者 RFC 3339，空表示不限
+func parseSearchTime(s string) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, errors.New("invalid date " + strconv.Quote(s) + " (examples: 2024-05-01, 2024-05-01T08:00:00Z)")
+	}
+	return t, nil
+}
+
+func (f *searchFilter) matchName(name string) bool {
+	name = strings.ToLower(name)
+	if f.glob {
+		ok, _ := path.Match(f.pattern, name)
+		return ok
+	}
+	return strings.Contains(name, f.pattern)
+}
+
+// 大小只看文件；after 含当时，before 不含
+func (f *searchFilter) matchInfo(info fs.FileInfo) bool {
+	switch {
+	case f.kind == "file" && info.IsDir(), f.kind == "dir" && !info.IsDir():
+		return false
+	case !info.IsDir() && (info.Size() < f.minSize || f.maxSize > 0 && info.Size() > f.maxSize):
+		return false
+	case !f.after.IsZero() && info.ModTime().Before(f.after):
+		return false
+	case !f.before.IsZero() && !info.ModTime().Before(f.before):
+		return false
+	}
+	return true
+}
+
+// 查询参数里的整数，没给用默认值，小于 lo 报错，超过 hi 按 hi
+func queryInt(q url.Values, key string, def, lo, hi int) (int, error) {
+	s := strings.TrimSpace(q.Get(key))
+	if s == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < lo {
+		return 0, errors.New("invalid " + key)
+	}
+	return min(n, hi), nil
+}
+
+// /api/search?share=&dir=&q=：在 dir 下面（含子文件夹）按名字找文件和文件夹，不区分大小写。
+// 可选 type=file|dir、minSize / maxSize（写法同 --max-upload-size）、after / before（修改时间）、
+// limit（条数）、timeout（秒）。mode=content 时按内容找文本文件（searchcontent.go），
+// 另外可以带 name（名字过滤）、context（上下文行数）、maxFileSize
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	if !isAuthed(r) {
+		writeAPIError(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+	sh, err := findShare(r.FormValue("share"))
+	if err != nil {
+		writeAPIError(w, r, codeNotFound, err.Error())
+		return
+	}
+	q := r.URL.Query()
+	rel := strings.TrimSpace(q.Get("dir"))
+	dir, err := joinSafe(sh.Path, rel)
+	if err != nil {
+		writeAPIError(w, r, codeInvalidName, "invalid dir")
+		return
+	}
+	if st, err := os.Stat(dir); err != nil || !st.IsDir() {
+		writeAPIError(w, r, codeNotFound, "folder not found")
+		return
+	}
+	filter, err := parseSearchFilter(q)
+	if err != nil {
+		writeAPIError(w, r, codeBadRequest, err.Error())
+		return
+	}
+	limit, err := queryInt(q, "limit", searchDefaultLimit, 1, searchMaxLimit)
+	if err != nil {
+		writeAPIError(w, r, codeBadRequest, err.Error())
+		return
+	}
+	seconds, err := queryInt(q, "timeout", int(searchDefaultTimeout/time.Second), 1, int(searchMaxTimeout/time.Second))
+	if err != nil {
+		writeAPIError(w, r, codeBadRequest, err.Error())
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(seconds)*time.Second)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+	done := searchDone{Type: "done"}
+	lastFlush := time.Now()
+	_ = filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil || p == dir {
+			return nil // 读不了的文件夹跳过
+		}
+		if isInternalName(d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		done.Scanned++
+		if !filter.matchName(d.Name()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !filter.matchInfo(info) {
+			return nil
+		}
+		m := searchMatch{Type: "match", listEntry: listEntry{
+			Name:    d.Name(),
+			IsDir:   d.IsDir(),
+			RelPath: relTo(sh, p),
+			Size:    info.Size(),
+			ModTime: info.ModTime().Format(time.RFC3339),
+		}}
+		if filter.content {
+			if !info.Mode().IsRegular() || info.Size() > filter.maxFileSize {
+				done.Skipped++
+				return nil
+			}
+			text, encoding, ok := readTextFile(p)
+			if !ok {
+				done.Skipped++
+				return nil
+			}
+			if m.Lines, m.MoreLines = grepLines(text, filter.text, filter.context); m.Lines == nil {
+				return nil
+			}
+			m.Encoding = encoding
+		}
+		if done.Matches == limit {
+			done.Truncated = true
+			return filepath.SkipAll
+		}
+		done.Matches++
+		_ = enc.Encode(m)
+		// 第一条马上发出去，之后最多每 200ms 刷一次
+		if done.Matches == 1 || time.Since(lastFlush) > 200*time.Millisecond {
+			_ = rc.Flush()
+			lastFlush = time.Now()
+		}
+		return nil
+	})
+	done.TimedOut = errors.Is(ctx.Err(), context.DeadlineExceeded)
+	_ = enc.Encode(done)
+}
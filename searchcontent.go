@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// 按内容搜索（/api/search?mode=content）：逐个读文本文件，返回包含 q 的行和上下文
+const (
+	searchDefaultContext     = 2
+	searchMaxContext         = 10
+	searchDefaultMaxFileSize = 10 << 20 // 比这大的文件不读，算在 skipped 里
+	searchMaxFileSizeCap     = 64 << 20
+	searchMaxLinesPerFile    = 50  // 一个文件最多返回多少个匹配行，多了设 moreLines
+	searchMaxLineRunes       = 400 // 压缩过的 JS 之类一行很长，只截匹配附近的一段
+	binarySniffLen           = 8000
+)
+
+type searchLine struct {
+	Line  int    `json:"line"` // 从 1 开始
+	Text  string `json:"text"`
+	Match bool   `json:"match,omitempty"` // false 的是上下文
+}
+
+// 开头 8000 字节里有 0 字节的当二进制跳过（和 git 的判断一样）。整个文件是合法 UTF-8 就按 UTF-8，
+// 否则试 GBK（按 GB18030 解码），Windows 上记事本等存的中文文件多是这个；都不是的话坏字节换成 U+FFFD
+func readTextFile(p string) (text, enc string, ok bool) {
+	b, err := os.ReadFile(p)
+	if err != nil || bytes.IndexByte(b[:min(len(b), binarySniffLen)], 0) >= 0 {
+		return "", "", false
+	}
+	if utf8.Valid(b) {
+		return strings.TrimPrefix(string(b), "\ufeff"), "utf-8", true
+	}
+	if s, err := simplifiedchinese.GB18030.NewDecoder().Bytes(b); err == nil && !bytes.ContainsRune(s, utf8.RuneError) {
+		return string(s), "gbk", true
+	}
+	return strings.ToValidUTF8(string(b), "\uFFFD"), "unknown", true
+}
+
+// 找出包含 needle（已经是小写）的行，每个前后带 n 行上下文，挨着的合在一起、按行号排好。
+// 没有匹配返回 nil
+func grepLines(text, needle string, n int) ([]searchLine, bool) {
+	text = strings.TrimSuffix(text, "\n") // 最后一行的换行后面不算一行
+	lines := strings.Split(text, "\n")
+	lower := strings.Split(strings.ToLower(text), "\n") // ToLower 不动换行，行数一样
+	hits := map[int]bool{}
+	var order []int
+	more := false
+	for i, l := range lower {
+		if !strings.Contains(l, needle) {
+			continue
+		}
+		if len(order) == searchMaxLinesPerFile {
+			more = true
+			break
+		}
+		hits[i] = true
+		order = append(order, i)
+	}
+	if len(order) == 0 {
+		return nil, false
+	}
+	var out []searchLine
+	next := 0 // 下一个还没输出的行
+	for _, h := range order {
+		for i := max(h-n, next); i <= min(h+n, len(lines)-1); i++ {
+			out = append(out, searchLine{
+				Line:  i + 1,
+				Text:  clipLine(strings.TrimSuffix(lines[i], "\r"), lower[i], needle, hits[i]),
+				Match: hits[i],
+			})
+			next = i + 1
+		}
+	}
+	return out, more
+}
+
+// 太长的行只留一段；匹配行从匹配位置前面一点开始截。
+// ToLower 逐个字符转换，字符数不变，所以小写里的位置可以直接用在原文上
+func clipLine(line, lower, needle string, match bool) string {
+	runes := []rune(line)
+	if len(runes) <= searchMaxLineRunes {
+		return line
+	}
+	start := 0
+	if idx := strings.Index(lower, needle); match && idx >= 0 {
+		start = max(0, utf8.RuneCountInString(lower[:idx])-searchMaxLineRunes/4)
+	}
+	end := min(len(runes), start+searchMaxLineRunes)
+	s := string(runes[start:end])
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(runes) {
+		s += "…"
+	}
+	return s
+}
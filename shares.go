@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+// 一个共享目录：浏览器里按名字选，readOnly 的只能看和下载
+type share struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	ReadOnly bool   `json:"readOnly,omitempty"`
+}
+
+type shareInfo struct {
+	Name     string `json:"name"`
+	ReadOnly bool   `json:"readOnly"`
+}
+
+// 启动时确定，之后只读；第一个是默认共享
+var shares []*share
+
+// 命令行 --share name=path，可以重复
+type shareFlag struct {
+	list     *[]share
+	readOnly bool
+}
+
+func (f shareFlag) String() string { return "" }
+
+func (f shareFlag) Set(v string) error {
+	name, path, ok := strings.Cut(v, "=")
+	if !ok {
+		return fmt.Errorf("want name=path, got %q", v)
+	}
+	*f.list = append(*f.list, share{Name: strings.TrimSpace(name), Path: strings.TrimSpace(path), ReadOnly: f.readOnly})
+	return nil
+}
+
+// 共享名会出现在 URL 和界面里，限制一下字符
+func validateShareName(name string) error {
+	if name == "" {
+		return fmt.Errorf("share name is empty")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\\?#&=%\"<>") {
+		return fmt.Errorf("invalid share name %q", name)
+	}
+	return nil
+}
+
+// --root 那个共享用目录名当共享名
+func defaultShareName(path string) string {
+	name := filepath.Base(filepath.Clean(path))
+	if validateShareName(name) != nil {
+		return "root"
+	}
+	return name
+}
+
+// 检查名字唯一、路径可用，路径转成绝对路径
+func normalizeShares(list []share) ([]*share, error) {
+	seen := make(map[string]bool)
+	out := make([]*share, 0, len(list))
+	for _, s := range list {
+		s.Name = strings.TrimSpace(s.Name)
+		if err := validateShareName(s.Name); err != nil {
+			return nil, err
+		}
+		key := strings.ToLower(s.Name)
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate share name %q", s.Name)
+		}
+		seen[key] = true
+		s.Path = expandHome(strings.TrimSpace(s.Path))
+		if s.Path == "" {
+			return nil, fmt.Errorf("share %q has no path", s.Name)
+		}
+		if abs, err := filepath.Abs(s.Path); err == nil {
+			s.Path = abs
+		}
+		if err := validateRoot(s.Path); err != nil {
+			return nil, fmt.Errorf("share %q: %v", s.Name, err)
+		}
+		sh := s
+		out = append(out, &sh)
+	}
+	return out, nil
+}
+
+func findShare(name string) (*share, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		if len(shares) == 0 {
+			return nil, fmt.Errorf("no shares configured")
+		}
+		return shares[0], nil
+	}
+	for _, s := range shares {
+		if strings.EqualFold(s.Name, name) {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown share %q", name)
+}
+
+// 从 query / 表单里取 share 参数，不带就是默认共享
+func shareFromRequest(w http.ResponseWriter, r *http.Request) (*share, bool) {
+	sh, err := findShare(r.FormValue("share"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return nil, false
+	}
+	return sh, true
+}
+
+// 写操作前调用，只读共享直接 403
+func requireWritable(w http.ResponseWriter, sh *share) bool {
+	if sh.ReadOnly {
+		http.Error(w, "share "+sh.Name+" is read-only", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+func shareInfos() []shareInfo {
+	out := make([]shareInfo, 0, len(shares))
+	for _, s := range shares {
+		out = append(out, shareInfo{Name: s.Name, ReadOnly: s.ReadOnly})
+	}
+	return out
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 回收站：/api/delete 默认不直接删，而是挪到共享下的 .filetransfer/.trash 里，
+// 和断点续传的记录一样，每一项是 <id>（原来的文件或文件夹）+ <id>.json（从哪删的、什么时候、谁删的）。
+// 放在同一个共享里，挪进挪出一般只是 rename，共享里挂着别的盘时才要复制；过了保留期由后台自动清掉
+
+// 回收站保留多久，--trash-days，启动时设置；0 表示不用回收站，删除就是真删
+var trashRetention = defaultTrashDays * 24 * time.Hour
+
+type trashInfo struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"` // 原来的位置，相对共享根目录
+	IsDir     bool      `json:"isDir"`
+	Size      int64     `json:"size"` // 文件夹是里面所有文件的总大小
+	Deleted   time.Time `json:"deleted"`
+	Client    string    `json:"client"` // 删除请求来自哪个 IP
+	UserAgent string    `json:"userAgent,omitempty"`
+	Expires   time.Time `json:"expires"` // 列表时按当前保留期算出来，不存盘
+}
+
+type trashListResponse struct {
+	Share         string      `json:"share"`
+	RetentionDays int         `json:"retentionDays"`
+	Items         []trashInfo `json:"items"`
+}
+
+type trashRequest struct {
+	Share    string   `json:"share"`
+	IDs      []string `json:"ids"`
+	All      bool     `json:"all"`      // purge 时清空整个回收站
+	Conflict string   `json:"conflict"` // restore 时原位置已经有东西怎么办，同 /upload
+}
+
+type trashResponse struct {
+	Share string `json:"share"`
+	resultList
+}
+
+func trashDir(sh *share) string {
+	return filepath.Join(sh.Path, metaDirName, ".trash")
+}
+
+func trashEnabled() bool {
+	return trashRetention > 0
+}
+
+func requestClient(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// 文件夹里所有文件的总大小，读不了的跳过
+func treeSize(full string) int64 {
+	var total int64
+	_ = filepath.WalkDir(full, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && d.Type().IsRegular() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// 往回收站里挪东西时拿读锁，清理没有记录的项目时拿写锁，免得把还没写记录的当成残留删掉
+var trashMoveMu sync.RWMutex
+
+// 没有记录的项目和复制到一半的临时文件，至少放这么久才清，防万一
+const trashOrphanGrace = time.Hour
+
+// 把 full 挪进回收站。先挪再写记录：列表时没有东西的记录会被清掉，反过来的话复制到一半就会丢记录；
+// 写记录前崩了留下的东西由 cleanupTrashOrphans 清理。
+// full 在共享里挂的另一个盘上时改不了名，和移动一样复制进回收站，记录写好了再删掉原来的
+func moveToTrash(sh *share, full, rel string, r *http.Request) error {
+	dir := trashDir(sh)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	st, err := os.Lstat(full)
+	if err != nil {
+		return err
+	}
+	info := trashInfo{
+		ID:        newUploadID(),
+		Path:      filepath.ToSlash(rel),
+		IsDir:     st.IsDir(),
+		Size:      treeSize(full),
+		Deleted:   time.Now(),
+		Client:    requestClient(r),
+		UserAgent: r.UserAgent(),
+	}
+	trashMoveMu.RLock()
+	defer trashMoveMu.RUnlock()
+	dst := filepath.Join(dir, info.ID)
+	copied := false
+	err = os.Rename(full, dst)
+	if isCrossDevice(err) {
+		_, _, _, err = copyIntoPlace(r.Context(), full, dst, conflictFail, nil)
+		copied = true
+	}
+	if err != nil {
+		return err
+	}
+	if err := writeTrashInfo(sh, &info); err != nil {
+		if copied {
+			_ = os.RemoveAll(dst)
+		} else {
+			_ = os.Rename(dst, full)
+		}
+		return err
+	}
+	if copied {
+		// 复制时跳过的管道之类没有内容可留，跟着原来的一起删掉
+		if err := os.RemoveAll(full); err != nil {
+			return fmt.Errorf("copied to trash but could not remove the original: %w", err)
+		}
+	}
+	return nil
+}
+
+func writeTrashInfo(sh *share, info *trashInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(trashDir(sh), info.ID+".json"), b, 0644)
+}
+
+func loadTrashInfo(sh *share, id string) (*trashInfo, error) {
+	if !validUploadID(id) {
+		return nil, fs.ErrNotExist
+	}
+	b, err := os.ReadFile(filepath.Join(trashDir(sh), id+".json"))
+	if err != nil {
+		return nil, err
+	}
+	var info trashInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, err
+	}
+	info.ID = id
+	info.Expires = info.Deleted.Add(trashRetention)
+	return &info, nil
+}
+
+// 回收站里的所有项目，最近删的在前；只剩记录、东西已经没了的顺手清掉
+func listTrash(sh *share) []trashInfo {
+	items := []trashInfo{}
+	entries, err := os.ReadDir(trashDir(sh))
+	if err != nil {
+		return items
+	}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok {
+			continue
+		}
+		info, err := loadTrashInfo(sh, id)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(trashDir(sh), id)); errors.Is(err, fs.ErrNotExist) {
+			_ = os.Remove(filepath.Join(trashDir(sh), e.Name()))
+			continue
+		}
+		items = append(items, *info)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Deleted.After(items[j].Deleted) })
+	return items
+}
+
+// 先删东西再删记录，中途失败的话下次列表时会清掉剩下的记录
+func purgeTrashItem(sh *share, id string) error {
+	if !validUploadID(id) {
+		return fs.ErrNotExist
+	}
+	if err := os.RemoveAll(filepath.Join(trashDir(sh), id)); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(trashDir(sh), id+".json"))
+}
+
+// GET /api/trash?share=：列出回收站
+func handleTrash(w http.ResponseWriter, r *http.Request) {
+	if !isAuthed(r) {
+		writeAPIError(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+	sh, ok := shareFromRequest(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, trashListResponse{
+		Share:         sh.Name,
+		RetentionDays: int(trashRetention / (24 * time.Hour)),
+		Items:         listTrash(sh),
+	})
+}
+
+func decodeTrashRequest(w http.ResponseWriter, r *http.Request) (*share, *trashRequest, bool) {
+	if !isAuthed(r) {
+		writeAPIError(w, r, codeUnauthorized, "unauthorized")
+		return nil, nil, false
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil, nil, false
+	}
+	var req trashRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeAPIError(w, r, codeBadRequest, "bad json")
+		return nil, nil, false
+	}
+	sh, err := findShare(req.Share)
+	if err != nil {
+		writeAPIError(w, r, codeNotFound, err.Error())
+		return nil, nil, false
+	}
+	if sh.ReadOnly {
+		writeAPIError(w, r, codeReadOnly, "share "+sh.Name+" is read-only")
+		return nil, nil, false
+	}
+	return sh, &req, true
+}
+
+// POST /api/trash/restore：挪回原来的位置，原来的上级文件夹没了会重新建
+func handleTrashRestore(w http.ResponseWriter, r *http.Request) {
+	sh, req, ok := decodeTrashRequest(w, r)
+	if !ok {
+		return
+	}
+	policy, err := parseConflictPolicy(req.Conflict)
+	if err != nil {
+		writeAPIError(w, r, codeBadRequest, err.Error())
+		return
+	}
+	resp := &trashResponse{Share: sh.Name, resultList: resultList{Files: []fileResult{}}}
+	for _, id := range req.IDs {
+		resp.add(restoreTrashItem(r.Context(), sh, id, policy))
+	}
+	writeJSON(w, resp.status(), resp)
+}
+
+func restoreTrashItem(ctx context.Context, sh *share, id string, policy conflictPolicy) fileResult {
+	res := fileResult{Name: id, Status: resultFailed}
+	info, err := loadTrashInfo(sh, id)
+	if err != nil {
+		res.Code, res.Error = codeNotFound, "not in trash"
+		return res
+	}
+	res.Name, res.Size = info.Path, info.Size
+	dst, err := joinSafe(sh.Path, info.Path)
+	if err != nil || dst == sh.Path {
+		res.Code, res.Error = codeInvalidName, "invalid original path"
+		return res
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		res.Code, res.Error = codeIO, err.Error()
+		return res
+	}
+	src := filepath.Join(trashDir(sh), id)
+	finalPath, outcome, err := moveIntoPlace(src, dst, policy)
+	if isCrossDevice(err) {
+		// 原来的位置在另一个盘上，复制回去再删掉回收站里的
+		if finalPath, outcome, _, err = copyIntoPlace(ctx, src, dst, policy, nil); err == nil {
+			_ = os.RemoveAll(src)
+		}
+	}
+	switch {
+	case errors.Is(err, errSkippedFile):
+		res.Status, res.Code, res.Error = resultSkipped, codeExists, "already exists"
+		return res
+	case err != nil:
+		res.Code, res.Error = uploadErrorCode(err), err.Error()
+		return res
+	}
+	_ = os.Remove(filepath.Join(trashDir(sh), id+".json"))
+	res.Status, res.Outcome = resultOK, outcome
+	if relPath, err := filepath.Rel(sh.Path, finalPath); err == nil {
+		res.Path = filepath.ToSlash(relPath)
+	}
+	fmt.Println("已从回收站恢复:", finalPath)
+	return res
+}
+
+// POST /api/trash/purge：彻底删除指定的项目，all=true 清空回收站
+func handleTrashPurge(w http.ResponseWriter, r *http.Request) {
+	sh, req, ok := decodeTrashRequest(w, r)
+	if !ok {
+		return
+	}
+	ids := req.IDs
+	if req.All {
+		ids = nil
+		for _, item := range listTrash(sh) {
+			ids = append(ids, item.ID)
+		}
+	}
+	resp := &trashResponse{Share: sh.Name, resultList: resultList{Files: []fileResult{}}}
+	for _, id := range ids {
+		res := fileResult{Name: id, Status: resultFailed}
+		info, err := loadTrashInfo(sh, id)
+		if err != nil {
+			res.Code, res.Error = codeNotFound, "not in trash"
+			resp.add(res)
+			continue
+		}
+		res.Name, res.Size = info.Path, info.Size
+		if err := purgeTrashItem(sh, id); err != nil {
+			res.Code, res.Error = codeIO, err.Error()
+		} else {
+			res.Status = resultOK
+			fmt.Printf("已从回收站删除: %s (%s)\n", info.Path, requestClient(r))
+		}
+		resp.add(res)
+	}
+	writeJSON(w, resp.status(), resp)
+}
+
+// 清掉超过保留期的项目；保留期改成 0 时回收站里剩下的也一起清掉
+func cleanupTrash() {
+	for _, sh := range shares {
+		if sh.ReadOnly {
+			continue
+		}
+		for _, item := range listTrash(sh) {
+			if time.Now().Before(item.Expires) {
+				continue
+			}
+			if err := purgeTrashItem(sh, item.ID); err == nil {
+				fmt.Println("回收站过期清理:", item.Path)
+			}
+		}
+		cleanupTrashOrphans(sh)
+	}
+}
+
+// 写记录前崩了留下的没有记录的项目，以及跨盘复制到一半留下的临时文件。
+// cleanupTempFiles 不进 .filetransfer，这里的得自己清；正有东西往里挪时这一轮先不动
+func cleanupTrashOrphans(sh *share) {
+	if !trashMoveMu.TryLock() {
+		return
+	}
+	defer trashMoveMu.Unlock()
+	dir := trashDir(sh)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasSuffix(name, ".json") {
+			continue
+		}
+		if !strings.HasPrefix(name, tempFilePrefix) {
+			if _, err := os.Lstat(filepath.Join(dir, name+".json")); !errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+		}
+		info, err := e.Info()
+		if err != nil || time.Since(info.ModTime()) < trashOrphanGrace {
+			continue
+		}
+		if os.RemoveAll(filepath.Join(dir, name)) == nil {
+			fmt.Println("回收站清理残留:", filepath.Join(dir, name))
+		}
+	}
+}
+
+func startTrashJanitor() {
+	cleanupTrash()
+	go func() {
+		for range time.Tick(time.Hour) {
+			cleanupTrash()
+		}
+	}()
+}
@@ -0,0 +1,501 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 断点续传：实现 tus 1.0 的核心协议 + creation / termination / expiration 扩展。
+// 未完成的文件放在共享目录下的 .filetransfer/uploads 里，和最终文件同一个盘，完成后直接 rename。
+const (
+	tusVersion    = "1.0.0"
+	tusPathPrefix = "/tus/"
+	tusExpiry     = 7 * 24 * time.Hour
+)
+
+// 每个共享下存放内部数据的隐藏目录，列表 / 打包 / 下载都看不到它
+const metaDirName = ".filetransfer"
+
+type tusInfo struct {
+	ID       string    `json:"id"`
+	Share    string    `json:"share"`
+	Target   string    `json:"target"`
+	Filename string    `json:"filename"` // 相对 Target 的路径，文件夹上传时带子目录
+	Length   int64     `json:"length"`
+	Created  time.Time `json:"created"`
+	Conflict string    `json:"conflict,omitempty"`
+	SHA256   string    `json:"sha256,omitempty"` // 客户端给的期望值
+	Hash     string    `json:"hash,omitempty"`   // 完成后算出来的 SHA-256
+	Done     bool      `json:"done,omitempty"`
+	Path     string    `json:"path,omitempty"`    // 完成后的相对路径
+	Outcome  string    `json:"outcome,omitempty"` // created / overwritten / renamed / skipped
+}
+
+// 同一个上传同时只允许一个 PATCH；完成后或者过期清理时删掉
+var tusLocks sync.Map
+
+func tusUploadsDir(sh *share) string {
+	return filepath.Join(sh.Path, metaDirName, "uploads")
+}
+
+func newUploadID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%032x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// id 会拼进文件名，只接受 32 位十六进制
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// Upload-Metadata: "key base64,key base64"
+func parseTusMetadata(header string) map[string]string {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		key, val, _ := strings.Cut(strings.TrimSpace(pair), " ")
+		if key == "" {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(val))
+		if err != nil {
+			continue
+		}
+		meta[key] = string(decoded)
+	}
+	return meta
+}
+
+func writeTusInfo(sh *share, info *tusInfo) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(tusUploadsDir(sh), info.ID+".json"), b, 0644)
+}
+
+// 按 id 在各个可写共享里找上传记录
+func loadTusInfo(id string) (*share, *tusInfo, error) {
+	if !validUploadID(id) {
+		return nil, nil, fs.ErrNotExist
+	}
+	for _, sh := range shares {
+		if sh.ReadOnly {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(tusUploadsDir(sh), id+".json"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		var info tusInfo
+		if err := json.Unmarshal(b, &info); err != nil {
+			return nil, nil, err
+		}
+		return sh, &info, nil
+	}
+	return nil, nil, fs.ErrNotExist
+}
+
+func tusPartPath(sh *share, id string) string {
+	return filepath.Join(tusUploadsDir(sh), id+".part")
+}
+
+func handleTus(w http.ResponseWriter, r *http.Request) {
+	if !isAuthed(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	w.Header().Set("Tus-Resumable", tusVersion)
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Tus-Version", tusVersion)
+		w.Header().Set("Tus-Extension", "creation,termination,expiration")
+		if maxUploadSize > 0 {
+			w.Header().Set("Tus-Max-Size", strconv.FormatInt(maxUploadSize, 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if r.Header.Get("Tus-Resumable") != tusVersion {
+		w.Header().Set("Tus-Version", tusVersion)
+		http.Error(w, "unsupported tus version", http.StatusPreconditionFailed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, tusPathPrefix)
+	if id == "" {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		tusCreate(w, r)
+		return
+	}
+	switch r.Method {
+	case http.MethodHead:
+		tusHead(w, id)
+	case http.MethodPatch:
+		tusPatch(w, r, id)
+	case http.MethodDelete:
+		tusDelete(w, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func tusCreate(w http.ResponseWriter, r *http.Request) {
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		http.Error(w, "invalid Upload-Length", http.StatusBadRequest)
+		return
+	}
+	if maxUploadSize > 0 && length > maxUploadSize {
+		http.Error(w, fmt.Sprintf("file too large (limit %d bytes)", maxUploadSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+	meta := parseTusMetadata(r.Header.Get("Upload-Metadata"))
+	sh, err := findShare(meta["share"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if !requireWritable(w, sh) {
+		return
+	}
+	target := strings.TrimSpace(meta["target"])
+	if _, err := joinSafe(sh.Path, target); err != nil {
+		http.Error(w, "invalid target dir", http.StatusBadRequest)
+		return
+	}
+	// 文件夹上传时 relativePath 是 "dir/sub/file.txt"，普通上传只有 filename
+	name := meta["relativePath"]
+	if name == "" {
+		name = meta["filename"]
+	}
+	name, err = cleanUploadPath(name)
+	if err != nil {
+		http.Error(w, "invalid filename", http.StatusBadRequest)
+		return
+	}
+	dstPath, err := joinSafe(sh.Path, path.Join(filepath.ToSlash(target), name))
+	if err != nil {
+		http.Error(w, "invalid filename", http.StatusBadRequest)
+		return
+	}
+	policy, err := parseConflictPolicy(meta["conflict"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	expected, err := normalizeSHA256(meta["sha256"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// skip / fail 在创建时就能判断，免得白传一遍；完成时还会再检查一次
+	if policy == conflictSkip || policy == conflictFail {
+		if _, err := os.Lstat(dstPath); err == nil {
+			tusConflictError(w, policy)
+			return
+		}
+	}
+
+	if err := os.MkdirAll(tusUploadsDir(sh), 0755); err != nil {
+		http.Error(w, "failed to prepare upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	info := &tusInfo{
+		ID:       newUploadID(),
+		Share:    sh.Name,
+		Target:   filepath.ToSlash(target),
+		Filename: name,
+		Length:   length,
+		Created:  time.Now(),
+		Conflict: string(policy),
+		SHA256:   expected,
+	}
+	f, err := os.Create(tusPartPath(sh, info.ID))
+	if err != nil {
+		http.Error(w, "failed to prepare upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	_ = f.Close()
+	if err := writeTusInfo(sh, info); err != nil {
+		_ = os.Remove(tusPartPath(sh, info.ID))
+		http.Error(w, "failed to prepare upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if length == 0 {
+		if err := tusFinish(sh, info); err != nil {
+			tusFinishError(w, info, err)
+			return
+		}
+		w.Header().Set("Upload-Conflict", info.Outcome)
+		w.Header().Set("Upload-Sha256", info.Hash)
+	}
+
+	w.Header().Set("Location", tusPathPrefix+info.ID)
+	w.Header().Set("Upload-Expires", info.Created.Add(tusExpiry).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// 当前进度就是 .part 的大小；已完成的返回 Upload-Length，客户端据此判断不用重传
+func tusOffset(sh *share, info *tusInfo) (int64, error) {
+	if info.Done {
+		return info.Length, nil
+	}
+	st, err := os.Stat(tusPartPath(sh, info.ID))
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+func tusHead(w http.ResponseWriter, id string) {
+	sh, info, err := loadTusInfo(id)
+	if err != nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	offset, err := tusOffset(sh, info)
+	if err != nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(info.Length, 10))
+	if info.Path != "" {
+		w.Header().Set("Upload-Path", info.Path)
+	}
+	if info.Outcome != "" {
+		w.Header().Set("Upload-Conflict", info.Outcome)
+	}
+	if info.Hash != "" {
+		w.Header().Set("Upload-Sha256", info.Hash)
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func tusPatch(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Header.Get("Content-Type") != "application/offset+octet-stream" {
+		http.Error(w, "content type must be application/offset+octet-stream", http.StatusUnsupportedMediaType)
+		return
+	}
+	lock, _ := tusLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := lock.(*sync.Mutex)
+	if !mu.TryLock() {
+		http.Error(w, "upload is busy", http.StatusLocked)
+		return
+	}
+	defer mu.Unlock()
+
+	sh, info, err := loadTusInfo(id)
+	if err != nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "invalid Upload-Offset", http.StatusBadRequest)
+		return
+	}
+	current, err := tusOffset(sh, info)
+	if err != nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	if offset != current || info.Done {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(current, 10))
+		http.Error(w, "offset mismatch", http.StatusConflict)
+		return
+	}
+
+	f, err := os.OpenFile(tusPartPath(sh, id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		http.Error(w, "failed to open upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// 断线时已经写进去的部分保留，下次从这里续传
+	n, copyErr := io.Copy(f, io.LimitReader(r.Body, info.Length-offset))
+	offset += n
+	if copyErr == nil && offset == info.Length {
+		// 最后一块写完先落盘再 rename，断电也不会留下内容不全的正式文件
+		copyErr = f.Sync()
+	}
+	closeErr := f.Close()
+	if copyErr == nil && closeErr != nil {
+		copyErr = closeErr
+	}
+
+	if offset == info.Length && copyErr == nil {
+		// 算摘要、挪到位、写 Done 都做完才放掉这个锁，期间重试的请求只会拿到 423，
+		// 之后再来的看到 Done 或者记录已经没了，不会再 finish 一次
+		err := tusFinish(sh, info)
+		tusLocks.Delete(id)
+		if err != nil {
+			tusFinishError(w, info, err)
+			return
+		}
+		w.Header().Set("Upload-Path", info.Path)
+		w.Header().Set("Upload-Conflict", info.Outcome)
+		w.Header().Set("Upload-Sha256", info.Hash)
+	} else if copyErr != nil {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		http.Error(w, "upload interrupted: "+copyErr.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func tusDelete(w http.ResponseWriter, id string) {
+	sh, info, err := loadTusInfo(id)
+	if err != nil {
+		http.Error(w, "upload not found", http.StatusNotFound)
+		return
+	}
+	_ = os.Remove(tusPartPath(sh, info.ID))
+	_ = os.Remove(filepath.Join(tusUploadsDir(sh), info.ID+".json"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// 上传完成：按冲突策略挪到目标目录，记录保留到过期，方便客户端确认
+func tusFinish(sh *share, info *tusInfo) error {
+	dstDir, err := joinSafe(sh.Path, info.Target)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dstDir, 0755); err != nil {
+		return err
+	}
+	policy, err := parseConflictPolicy(info.Conflict)
+	if err != nil {
+		return err
+	}
+	dstPath := filepath.Join(dstDir, filepath.FromSlash(info.Filename))
+	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
+		return err
+	}
+	partPath := tusPartPath(sh, info.ID)
+	// 续传可能跨了好几次连接甚至重启，最后统一把 .part 读一遍算摘要
+	sum, _, err := hashFile(partPath, "sha256")
+	if err != nil {
+		return err
+	}
+	info.Hash = sum
+	if info.SHA256 != "" && sum != info.SHA256 {
+		_ = os.Remove(partPath)
+		_ = os.Remove(filepath.Join(tusUploadsDir(sh), info.ID+".json"))
+		return errChecksumMismatch
+	}
+	finalPath, outcome, err := moveIntoPlace(partPath, dstPath, policy)
+	if errors.Is(err, errSkippedFile) {
+		_ = os.Remove(partPath)
+		info.Done = true
+		info.Outcome = "skipped"
+		return writeTusInfo(sh, info)
+	}
+	if errors.Is(err, errFileExists) {
+		_ = os.Remove(partPath)
+		_ = os.Remove(filepath.Join(tusUploadsDir(sh), info.ID+".json"))
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	rel, _ := filepath.Rel(sh.Path, finalPath)
+	info.Done = true
+	info.Path = filepath.ToSlash(rel)
+	info.Outcome = outcome
+	return writeTusInfo(sh, info)
+}
+
+func tusConflictError(w http.ResponseWriter, policy conflictPolicy) {
+	if policy == conflictSkip {
+		w.Header().Set("Upload-Conflict", "skipped")
+	} else {
+		w.Header().Set("Upload-Conflict", "failed")
+	}
+	http.Error(w, errFileExists.Error(), http.StatusConflict)
+}
+
+// tus checksum 扩展约定 460 表示校验失败
+const statusChecksumMismatch = 460
+
+func tusFinishError(w http.ResponseWriter, info *tusInfo, err error) {
+	if errors.Is(err, errFileExists) {
+		tusConflictError(w, conflictFail)
+		return
+	}
+	if errors.Is(err, errChecksumMismatch) {
+		w.Header().Set("Upload-Sha256", info.Hash)
+		http.Error(w, fmt.Sprintf("checksum mismatch: expected sha256 %s, got %s", info.SHA256, info.Hash), statusChecksumMismatch)
+		return
+	}
+	http.Error(w, "failed to save file: "+err.Error(), http.StatusInternalServerError)
+}
+
+// 清理过期的上传：.part / .json 最后一次写入都超过 tusExpiry 的整个删掉
+func cleanupTusUploads() {
+	for _, sh := range shares {
+		if sh.ReadOnly {
+			continue
+		}
+		dir := tusUploadsDir(sh)
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		lastActive := make(map[string]time.Time)
+		for _, e := range entries {
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			id := strings.TrimSuffix(e.Name(), filepath.Ext(e.Name()))
+			if info.ModTime().After(lastActive[id]) {
+				lastActive[id] = info.ModTime()
+			}
+		}
+		for id, t := range lastActive {
+			if time.Since(t) <= tusExpiry {
+				continue
+			}
+			_ = os.Remove(filepath.Join(dir, id+".part"))
+			_ = os.Remove(filepath.Join(dir, id+".json"))
+			tusLocks.Delete(id)
+		}
+	}
+}
+
+func startTusJanitor() {
+	cleanupTusUploads()
+	go func() {
+		for range time.Tick(time.Hour) {
+			cleanupTusUploads()
+		}
+	}()
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 每个文件的期望 SHA-256：multipart 里放在文件 part 的头上，或者用文件前面的 sha256 字段
+const checksumHeader = "X-Checksum-Sha256"
+
+// 上传中的临时文件前缀，和最终文件放在同一目录，成功后 rename，列表和打包里都看不到
+const tempFilePrefix = ".filetransfer-tmp-"
+
+// 单次 /upload 请求体的上限（字节），0 = 不限制；tus 上传按单个文件大小限制
+var maxUploadSize int64
+
+// multipart 边读边写：每个文件直接流进目标目录里的临时文件，不经过内存和系统临时目录。
+// share / target 字段要放在文件前面（curl -F 按顺序发送），也可以放在 URL 参数里。
+// 所有文件收完后一次性返回每个文件的结果，见 response.go
+func handleUpload(w http.ResponseWriter, r *http.Request) {
+	if !isAuthed(r) {
+		writeAPIError(w, r, codeUnauthorized, "unauthorized")
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if maxUploadSize > 0 {
+		if r.ContentLength > maxUploadSize {
+			writeAPIError(w, r, codeTooLarge, fmt.Sprintf("request too large (limit %d bytes)", maxUploadSize))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		writeAPIError(w, r, codeBadRequest, err.Error())
+		return
+	}
+
+	shareName := r.URL.Query().Get("share")
+	targetRel := strings.TrimSpace(r.URL.Query().Get("target"))
+	conflictText := r.URL.Query().Get("conflict")
+	var policy conflictPolicy
+	var sh *share
+	nextSHA := ""
+	resp := &uploadResponse{resultList: resultList{Files: []fileResult{}}}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if resp.fullDir == "" {
+				writeAPIError(w, r, uploadErrorCode(err), uploadReadError(err))
+				return
+			}
+			resp.Errors = append(resp.Errors, apiError{Code: uploadErrorCode(err), Error: uploadReadError(err)})
+			break
+		}
+
+		field := part.FormName()
+		if part.FileName() == "" {
+			// 普通字段，只认 share / target / conflict / sha256，值很短，限制一下长度
+			val, _ := io.ReadAll(io.LimitReader(part, 4096))
+			_ = part.Close()
+			if field == "sha256" {
+				// 对紧跟在后面的那个文件生效
+				nextSHA = string(val)
+				continue
+			}
+			if field != "share" && field != "target" && field != "conflict" {
+				continue
+			}
+			if resp.fullDir != "" {
+				resp.Errors = append(resp.Errors, apiError{Code: codeFieldOrder, Error: fmt.Sprintf("field %q must come before the files", field)})
+				continue
+			}
+			switch field {
+			case "share":
+				shareName = string(val)
+			case "target":
+				targetRel = strings.TrimSpace(string(val))
+			case "conflict":
+				conflictText = string(val)
+			}
+			continue
+		}
+		if field != "files" {
+			_ = part.Close()
+			continue
+		}
+
+		// 第一个文件到了才确定目标目录
+		if resp.fullDir == "" {
+			policy, err = parseConflictPolicy(conflictText)
+			if err != nil {
+				writeAPIError(w, r, codeBadRequest, err.Error())
+				return
+			}
+			sh, err = findShare(shareName)
+			if err != nil {
+				writeAPIError(w, r, codeNotFound, err.Error())
+				return
+			}
+			if sh.ReadOnly {
+				writeAPIError(w, r, codeReadOnly, "share "+sh.Name+" is read-only")
+				return
+			}
+			fullDir, err := joinSafe(sh.Path, targetRel)
+			if err != nil {
+				writeAPIError(w, r, codeInvalidName, "invalid target dir")
+				return
+			}
+			if err := os.MkdirAll(fullDir, 0755); err != nil {
+				writeAPIError(w, r, codeIO, "failed to ensure target dir: "+err.Error())
+				return
+			}
+			resp.Share = sh.Name
+			resp.Target = filepath.ToSlash(targetRel)
+			resp.fullDir = fullDir
+		}
+
+		resp.add(receiveUploadPart(part, sh, resp.fullDir, targetRel, policy, nextSHA))
+		nextSHA = ""
+		_ = part.Close()
+	}
+
+	if resp.fullDir == "" {
+		writeAPIError(w, r, codeBadRequest, "no files uploaded")
+		return
+	}
+	writeUploadResponse(w, r, resp)
+}
+
+// 收一个文件 part，结果（成功、跳过或失败）都装进 fileResult
+func receiveUploadPart(part *multipart.Part, sh *share, fullDir, targetRel string, policy conflictPolicy, expected string) fileResult {
+	name := partRelativePath(part)
+	res := fileResult{Name: name, Status: resultFailed}
+	rel, err := cleanUploadPath(name)
+	if err == nil {
+		_, err = joinSafe(sh.Path, path.Join(filepath.ToSlash(targetRel), rel))
+	}
+	if err != nil {
+		res.Code, res.Error = codeInvalidName, "invalid file name"
+		return res
+	}
+	dstFull := filepath.Join(fullDir, filepath.FromSlash(rel))
+	if err := os.MkdirAll(filepath.Dir(dstFull), 0755); err != nil {
+		res.Code, res.Error = codeIO, err.Error()
+		return res
+	}
+	if h := part.Header.Get(checksumHeader); h != "" {
+		expected = h
+	}
+	expected, err = normalizeSHA256(expected)
+	if err != nil {
+		res.Code, res.Error = codeInvalidChecksum, err.Error()
+		return res
+	}
+	up, err := receiveUploadFile(part, dstFull, policy, expected)
+	res.Size, res.SHA256 = up.Size, up.SHA256
+	switch {
+	case errors.Is(err, errSkippedFile):
+		res.Status, res.Code, res.Error = resultSkipped, codeExists, "already exists"
+	case errors.Is(err, errChecksumMismatch):
+		res.Code = codeChecksumMismatch
+		res.Error = fmt.Sprintf("checksum mismatch: expected sha256 %s, got %s", expected, up.SHA256)
+	case err != nil:
+		res.Code, res.Error = uploadErrorCode(err), uploadReadError(err)
+	default:
+		res.Status, res.Outcome, res.full = resultOK, up.Outcome, up.Path
+		if relPath, err := filepath.Rel(sh.Path, up.Path); err == nil {
+			res.Path = filepath.ToSlash(relPath)
+		}
+	}
+	return res
+}
+
+// multipart 的 FileName() 会去掉目录部分，文件夹上传要自己从 Content-Disposition 里取原始文件名
+func partRelativePath(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] == "" {
+		return part.FileName()
+	}
+	return params["filename"]
+}
+
+// 把浏览器给的文件名 / 相对路径（webkitRelativePath）规整成 "a/b/c.txt"，
+// 拒绝 .. 和程序内部目录；结果还要再过一遍 joinSafe
+func cleanUploadPath(name string) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	var parts []string
+	for _, seg := range strings.Split(name, "/") {
+		seg = strings.TrimSpace(seg)
+		if seg == "" || seg == "." {
+			continue
+		}
+		if seg == ".." || isInternalName(seg) {
+			return "", fmt.Errorf("invalid path %q", name)
+		}
+		parts = append(parts, seg)
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("empty file name")
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+func uploadReadError(err error) string {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return fmt.Sprintf("request too large (limit %d bytes)", tooLarge.Limit)
+	}
+	return err.Error()
+}
+
+// 同名文件的处理方式，请求里不指定就用服务端默认值
+type conflictPolicy string
+
+const (
+	conflictOverwrite conflictPolicy = "overwrite"
+	conflictRename    conflictPolicy = "rename"
+	conflictSkip      conflictPolicy = "skip"
+	conflictFail      conflictPolicy = "fail"
+)
+
+var defaultConflictPolicy = conflictRename
+
+var (
+	errFileExists   = errors.New("file already exists")
+	errSkippedFile  = errors.New("skipped, file already exists")
+	errFolderExists = errors.New("a folder with this name already exists") // overwrite 不会覆盖文件夹
+)
+
+func parseConflictPolicy(s string) (conflictPolicy, error) {
+	switch p := conflictPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case "":
+		return defaultConflictPolicy, nil
+	case conflictOverwrite, conflictRename, conflictSkip, conflictFail:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid conflict policy %q (overwrite, rename, skip or fail)", s)
+}
+
+// 结果描述，写进每个文件的上传结果里
+const (
+	outcomeCreated     = "created"
+	outcomeOverwritten = "overwritten"
+	outcomeRenamed     = "renamed"
+	outcomeTrashed     = "trashed" // 删除：挪进了回收站
+	outcomeDeleted     = "deleted" // 删除：直接删掉了
+)
+
+// 拆出扩展名，.tar.gz 这类双扩展名当成一个整体
+func splitExt(name string) (string, string) {
+	lower := strings.ToLower(name)
+	for _, ext := range []string{".tar.gz", ".tar.bz2", ".tar.xz", ".tar.zst"} {
+		if strings.HasSuffix(lower, ext) && len(name) > len(ext) {
+			return name[:len(name)-len(ext)], name[len(name)-len(ext):]
+		}
+	}
+	ext := filepath.Ext(name)
+	if ext == name {
+		return name, ""
+	}
+	return strings.TrimSuffix(name, ext), ext
+}
+
+// 找一个不存在的名字："a.txt" -> "a (1).txt"
+func nextFreeName(dstPath string) (string, error) {
+	dir := filepath.Dir(dstPath)
+	stem, ext := splitExt(filepath.Base(dstPath))
+	for i := 1; i < 10000; i++ {
+		candidate := filepath.Join(dir, fmt.Sprintf("%s (%d)%s", stem, i, ext))
+		if _, err := os.Lstat(candidate); errors.Is(err, fs.ErrNotExist) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free name for %s", filepath.Base(dstPath))
+}
+
+// 同一时间只让一个上传决定最终文件名，避免两个 rename 选中同一个名字
+var placeMu sync.Mutex
+
+// 按冲突策略决定最终路径；skip / fail 返回 errSkippedFile / errFileExists
+func resolveConflict(dstPath string, policy conflictPolicy) (string, string, error) {
+	st, err := os.Lstat(dstPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return dstPath, outcomeCreated, nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	switch policy {
+	case conflictOverwrite:
+		if st.IsDir() {
+			return "", "", errFolderExists
+		}
+		return dstPath, outcomeOverwritten, nil
+	case conflictSkip:
+		return "", "", errSkippedFile
+	case conflictFail:
+		return "", "", errFileExists
+	}
+	p, err := nextFreeName(dstPath)
+	if err != nil {
+		return "", "", err
+	}
+	return p, outcomeRenamed, nil
+}
+
+// 一个文件的上传结果
+type uploadResult struct {
+	Path    string // 最终的绝对路径
+	Outcome string // created / overwritten / renamed
+	Size    int64
+	SHA256  string
+}
+
+// 先写到同目录下的隐藏临时文件，边写边算 SHA-256，fsync 后再按冲突策略 rename 成最终文件名；
+// 传到一半断线或校验不通过都不会留下正式文件。expectedSHA 为空表示不校验
+func receiveUploadFile(src io.Reader, dstPath string, policy conflictPolicy, expectedSHA string) (uploadResult, error) {
+	// skip / fail 先看一眼，已存在就不用白收一遍
+	if policy == conflictSkip || policy == conflictFail {
+		if _, err := os.Lstat(dstPath); err == nil {
+			if policy == conflictSkip {
+				return uploadResult{}, errSkippedFile
+			}
+			return uploadResult{}, errFileExists
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dstPath), tempFilePrefix+"*")
+	if err != nil {
+		return uploadResult{}, err
+	}
+	h := sha256.New()
+	// CreateTemp 建出来是 0600，改成和 os.Create 一样的 0644，别的用户和服务才读得到
+	err = tmp.Chmod(0644)
+	var n int64
+	if err == nil {
+		n, err = io.Copy(io.MultiWriter(tmp, h), src)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if err == nil && expectedSHA != "" && sum != expectedSHA {
+		err = errChecksumMismatch
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return uploadResult{Size: n, SHA256: sum}, err
+	}
+	finalPath, outcome, err := moveIntoPlace(tmp.Name(), dstPath, policy)
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return uploadResult{Size: n, SHA256: sum}, err
+	}
+	return uploadResult{Path: finalPath, Outcome: outcome, Size: n, SHA256: sum}, nil
+}
+
+// 已经写好的文件（例如断点续传的 .part）按冲突策略挪到最终位置
+func moveIntoPlace(srcPath, dstPath string, policy conflictPolicy) (string, string, error) {
+	placeMu.Lock()
+	defer placeMu.Unlock()
+	finalPath, outcome, err := resolveConflict(dstPath, policy)
+	if err != nil {
+		return "", "", err
+	}
+	if err := os.Rename(srcPath, finalPath); err != nil {
+		return "", "", err
+	}
+	return finalPath, outcome, nil
+}
+
+type existsRequest struct {
+	Share  string   `json:"share"`
+	Target string   `json:"target"`
+	Names  []string `json:"names"`
+}
+
+type existsResponse struct {
+	Existing []string `json:"existing"`
+}
+
+// 上传前检查哪些文件（可以带相对路径）在目标目录里已经存在，给页面弹窗用
+func handleExists(w http.ResponseWriter, r *http.Request) {
+	if !isAuthed(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req existsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad json", http.StatusBadRequest)
+		return
+	}
+	sh, err := findShare(req.Share)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	dir, err := joinSafe(sh.Path, req.Target)
+	if err != nil {
+		http.Error(w, "invalid target dir", http.StatusBadRequest)
+		return
+	}
+	resp := existsResponse{Existing: []string{}}
+	for _, name := range req.Names {
+		rel, err := cleanUploadPath(name)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(rel))); err == nil {
+			resp.Existing = append(resp.Existing, name)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// 启动时清掉上次异常退出留下的临时文件；只删启动前就存在的，不影响刚开始的上传
+func cleanupTempFiles(before time.Time) {
+	for _, sh := range shares {
+		if sh.ReadOnly {
+			continue
+		}
+		_ = filepath.WalkDir(sh.Path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if d.IsDir() && d.Name() == metaDirName {
+				return filepath.SkipDir
+			}
+			if !strings.HasPrefix(d.Name(), tempFilePrefix) {
+				return nil
+			}
+			// 跨文件系统移动 / 复制文件夹时的临时文件夹也是这个前缀
+			if info, err := d.Info(); err == nil && info.ModTime().Before(before) {
+				if os.RemoveAll(p) == nil {
+					fmt.Println("已清理未完成的上传 / 复制:", p)
+				}
+			}
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"hash/crc32"
+	"io"
+	"os"
+	"runtime"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// 并行压缩：worker goroutine 各自把文件压好放进缓冲，写 ZIP 的一方按原来的顺序
+// 用 CreateRaw 直接写进去。同时在途的文件数有上限，缓冲大了落到临时文件，内存占用可控。
+// 大文件不走这里，由写的一方边读边压，worker 同时准备后面的小文件。
+
+// 压缩用的 worker 数，--zip-workers，启动时设置；1 就是原来的单线程
+var zipWorkers = runtime.NumCPU()
+
+const (
+	zipParallelMaxSize = 64 << 20 // 超过这个大小的文件不进 worker
+	zipSpillThreshold  = 4 << 20  // 单个缓冲超过这个大小改写临时文件
+)
+
+// 先放内存，超过 zipSpillThreshold 转到临时文件
+type spillBuffer struct {
+	mem  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+func (b *spillBuffer) Write(p []byte) (int, error) {
+	if b.file == nil && b.mem.Len()+len(p) > zipSpillThreshold {
+		f, err := os.CreateTemp("", "filetransfer-zip-*")
+		if err != nil {
+			return 0, err
+		}
+		b.file = f
+		if _, err := b.mem.WriteTo(f); err != nil {
+			return 0, err
+		}
+	}
+	var n int
+	var err error
+	if b.file != nil {
+		n, err = b.file.Write(p)
+	} else {
+		n, err = b.mem.Write(p)
+	}
+	b.size += int64(n)
+	return n, err
+}
+
+func (b *spillBuffer) WriteTo(w io.Writer) (int64, error) {
+	if b.file == nil {
+		return b.mem.WriteTo(w)
+	}
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return io.Copy(w, b.file)
+}
+
+func (b *spillBuffer) Close() {
+	b.mem = bytes.Buffer{}
+	if b.file != nil {
+		_ = b.file.Close()
+		_ = os.Remove(b.file.Name())
+		b.file = nil
+	}
+}
+
+type zipJob struct {
+	e       *archiveEntry
+	hdr     *zip.FileHeader
+	buf     spillBuffer
+	openErr error // 打不开，按跳过处理
+	err     error // 读或压到一半出错，包已经没法完整了
+	done    chan struct{}
+}
+
+// CreateRaw 不会像 CreateHeader 那样补 UTF-8 标志、版本和扩展时间戳，这里自己补上
+func prepareRawHeader(hdr *zip.FileHeader) {
+	if !isASCII(hdr.Name) && utf8.ValidString(hdr.Name) {
+		hdr.Flags |= zipFlagUTF8
+	}
+	hdr.CreatorVersion = hdr.CreatorVersion&0xff00 | zipVersion20
+	hdr.ReaderVersion = zipVersion20
+	hdr.ModifiedDate, hdr.ModifiedTime = dosDateTime(hdr.Modified)
+	hdr.Extra = appendExtTime(hdr.Extra, hdr.Modified)
+}
+
+func (job *zipJob) run(level int) {
+	defer close(job.done)
+	f, err := os.Open(job.e.path)
+	if err != nil {
+		job.openErr = err
+		return
+	}
+	defer f.Close()
+	method, src := chooseZipMethod(job.e.name, f)
+	hdr := &zip.FileHeader{Name: job.e.name, Method: method, Modified: job.e.modTime}
+	hdr.SetMode(job.e.mode)
+
+	crc := crc32.NewIEEE()
+	var n int64
+	if method == zip.Store {
+		n, err = io.Copy(io.MultiWriter(&job.buf, crc), src)
+	} else {
+		fw, ferr := flate.NewWriter(&job.buf, level)
+		if ferr != nil {
+			job.err = ferr
+			return
+		}
+		n, err = io.Copy(io.MultiWriter(fw, crc), src)
+		if cerr := fw.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		job.err = err
+		return
+	}
+	hdr.CRC32 = crc.Sum32()
+	hdr.UncompressedSize64 = uint64(n)
+	hdr.CompressedSize64 = uint64(job.buf.size)
+	prepareRawHeader(hdr)
+	job.hdr = hdr
+}
+
+// 按 entries 的顺序给能并行的文件建任务，后台按顺序分给 worker。
+// 写的一方每写完一个任务调用 release，worker 最多领先 2*zipWorkers 个文件
+type zipPipeline struct {
+	jobs    []*zipJob // 和 entries 一一对应，nil 表示由写的一方自己处理
+	pending []*zipJob
+	sem     chan struct{}
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+	once    sync.Once
+}
+
+func startZipPipeline(entries []*archiveEntry, level int) *zipPipeline {
+	p := &zipPipeline{jobs: make([]*zipJob, len(entries))}
+	if zipWorkers <= 1 {
+		return p
+	}
+	for i, e := range entries {
+		if e.isDir() || e.data != nil || e.size > zipParallelMaxSize {
+			continue
+		}
+		p.jobs[i] = &zipJob{e: e, done: make(chan struct{})}
+		p.pending = append(p.pending, p.jobs[i])
+	}
+	p.sem = make(chan struct{}, zipWorkers*2)
+	p.stopCh = make(chan struct{})
+
+	work := make(chan *zipJob)
+	for i := 0; i < zipWorkers; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for job := range work {
+				job.run(level)
+			}
+		}()
+	}
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		defer close(work)
+		for _, job := range p.pending {
+			select {
+			case p.sem <- struct{}{}:
+			case <-p.stopCh:
+				return
+			}
+			select {
+			case work <- job:
+			case <-p.stopCh:
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// 第 i 个条目的任务，等它压完；nil 表示不在 worker 里处理
+func (p *zipPipeline) wait(i int) *zipJob {
+	job := p.jobs[i]
+	if job != nil {
+		<-job.done
+	}
+	return job
+}
+
+func (p *zipPipeline) release(job *zipJob) {
+	job.buf.Close()
+	<-p.sem
+}
+
+// 写完或者中途放弃时调用：停掉 worker，删掉还没用到的缓冲
+func (p *zipPipeline) stop() {
+	if p.stopCh == nil {
+		return
+	}
+	p.once.Do(func() {
+		close(p.stopCh)
+		p.wg.Wait()
+		for _, job := range p.pending {
+			job.buf.Close()
+		}
+	})
+}
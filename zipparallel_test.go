@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/flate"
+)
+
+// 丢掉输出的 ResponseWriter，只量压缩和打包本身
+type discardResponse struct{ h http.Header }
+
+func (d *discardResponse) Header() http.Header         { return d.h }
+func (d *discardResponse) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardResponse) WriteHeader(int)             {}
+
+// 像文本一样能压缩的内容，每个文件不一样，免得压缩器占便宜
+func benchText(rng *rand.Rand, size int) []byte {
+	words := strings.Fields("the quick brown fox jumps over lazy dog 文件 传输 局域网 upload download share folder archive 2024 report")
+	var b strings.Builder
+	for b.Len() < size {
+		b.WriteString(words[rng.Intn(len(words))])
+		if rng.Intn(12) == 0 {
+			b.WriteString("\n")
+		} else {
+			b.WriteString(" ")
+		}
+	}
+	return []byte(b.String()[:size])
+}
+
+func benchTree(b *testing.B, files, size int) string {
+	b.Helper()
+	dir := b.TempDir()
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < files; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("d%02d", i%20), fmt.Sprintf("f%05d.txt", i))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.WriteFile(p, benchText(rng, size), 0644); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// go test -run '^$' -bench WriteDeflateZip -benchtime 5x
+func BenchmarkWriteDeflateZip(b *testing.B) {
+	trees := []struct {
+		name        string
+		files, size int
+	}{
+		{"small-2000x16K", 2000, 16 << 10},
+		{"large-4x16M", 4, 16 << 20},
+	}
+	workers := []int{1, 4}
+	if n := runtime.NumCPU(); n != 1 && n != 4 {
+		workers = append(workers, n)
+	}
+	saved := zipWorkers
+	defer func() { zipWorkers = saved }()
+	for _, t := range trees {
+		dir := benchTree(b, t.files, t.size)
+		for _, n := range workers {
+			b.Run(fmt.Sprintf("%s/workers=%d", t.name, n), func(b *testing.B) {
+				zipWorkers = n
+				b.SetBytes(int64(t.files * t.size))
+				for i := 0; i < b.N; i++ {
+					c := &archiveCollector{}
+					c.add(dir, "")
+					writeDeflateZip(&discardResponse{h: http.Header{}}, c, "bench.zip", flate.DefaultCompression)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,483 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// /download-zip?level=none（老写法 mode=store）：不压缩的 ZIP，按文件名和大小就能把整个包的布局算出来，
+// 所以能给 Content-Length 和 ETag，也能按 Range 从中间续传。同样的文件（名字、大小、
+// 修改时间、权限都没变）每次生成的字节完全一样。
+//
+// 本地文件头里不写 CRC（用 data descriptor），CRC 在读到那段数据时顺手算；
+// 续传时跳过的文件要等到写 data descriptor / 中央目录时再单独读一遍。
+
+const (
+	zipLocalHeaderLen   = 30
+	zipCentralHeaderLen = 46
+	zipEndLen           = 22
+	zip64EndLen         = 56
+	zip64LocatorLen     = 20
+	zipExtTimeLen       = 9 // 0x5455 扩展时间戳，只带 mtime
+	zip64LocalExtraLen  = 20
+	zip64CentralExtra   = 28
+	zipFlagDescriptor   = 0x0008
+	zipFlagUTF8         = 0x0800
+	zipVersion20        = 20
+	zipVersion45        = 45
+	zipCreatorUnix      = 3
+	uint32max           = 0xFFFFFFFF
+	uint16max           = 0xFFFF
+)
+
+type archiveEntry struct {
+	name    string // 包里的路径，目录以 / 结尾
+	path    string // 磁盘上的路径，目录和软链接为空
+	link    string // 软链接的目标，只有 tar 会保留软链接
+	data    []byte // 程序生成的内容（_SKIPPED_FILES.txt），不在磁盘上
+	size    int64
+	mode    fs.FileMode
+	modTime time.Time
+	offset  int64 // 本地文件头在包里的位置
+	zip64   bool
+
+	crc     uint32
+	crcPos  int64 // 顺序读到哪里了，crc 只覆盖 [0, crcPos)
+	crcDone bool
+}
+
+func (e *archiveEntry) isDir() bool { return e.mode.IsDir() }
+
+// 包里的一段：固定字节、某个文件的内容，或者要等 CRC 才能生成的部分
+type zipSegment struct {
+	start int64
+	size  int64
+	data  []byte
+	entry *archiveEntry
+	build func() ([]byte, error)
+}
+
+type storedZip struct {
+	entries []*archiveEntry
+	segs    []*zipSegment
+	size    int64
+	etag    string
+	modTime time.Time
+	pos     int64
+
+	cur     *os.File
+	curPath string
+}
+
+// 同一个文件续传时不用再读一遍算 CRC；按路径 + 大小 + 修改时间缓存
+var (
+	zipCRCMu    sync.Mutex
+	zipCRCCache = make(map[string]uint32)
+)
+
+const zipCRCCacheMax = 100000
+
+func (e *archiveEntry) cacheKey() string {
+	return e.path + "\x00" + strconv.FormatInt(e.size, 10) + "\x00" + strconv.FormatInt(e.modTime.UnixNano(), 10)
+}
+
+// 按收集好的条目算出整个包的布局，不读文件内容；跳过的文件清单放在最后
+func newStoredZip(c *archiveCollector) *storedZip {
+	z := &storedZip{entries: c.entries}
+	if m := c.manifest(); m != nil {
+		z.entries = append(z.entries[:len(z.entries):len(z.entries)], m)
+	}
+	z.layout()
+	return z
+}
+
+func (z *storedZip) add(seg *zipSegment) {
+	if seg.data != nil {
+		seg.size = int64(len(seg.data))
+	}
+	seg.start = z.size
+	z.segs = append(z.segs, seg)
+	z.size += seg.size
+}
+
+func (z *storedZip) layout() {
+	h := sha256.New()
+	fmt.Fprintf(h, "stored-zip-v1\n")
+	var cdSize int64
+	for _, e := range z.entries {
+		fmt.Fprintf(h, "%q %d %o %d\n", e.name, e.size, uint32(e.mode), e.modTime.UnixNano())
+		h.Write(e.data)
+		if e.modTime.After(z.modTime) {
+			z.modTime = e.modTime
+		}
+
+		e.offset = z.size
+		e.zip64 = e.size >= uint32max || e.offset >= uint32max
+		z.add(&zipSegment{data: localHeader(e)})
+		if e.isDir() {
+			e.crcDone = true
+		} else {
+			z.add(&zipSegment{size: e.size, entry: e})
+			z.add(&zipSegment{size: descriptorLen(e), build: z.descriptor(e)})
+		}
+		cdSize += int64(zipCentralHeaderLen + len(e.name) + centralExtraLen(e))
+	}
+
+	cdStart := z.size
+	endSize := int64(zipEndLen)
+	if needZip64End(len(z.entries), cdStart, cdSize) {
+		endSize += zip64EndLen + zip64LocatorLen
+	}
+	z.add(&zipSegment{size: cdSize + endSize, build: func() ([]byte, error) {
+		return z.centralDirectory(cdStart, cdSize)
+	}})
+	z.etag = `"z-` + hex.EncodeToString(h.Sum(nil))[:32] + `"`
+}
+
+func needZip64End(n int, cdStart, cdSize int64) bool {
+	return n >= uint16max || cdStart >= uint32max || cdSize >= uint32max
+}
+
+func descriptorLen(e *archiveEntry) int64 {
+	if e.zip64 {
+		return 24
+	}
+	return 16
+}
+
+func zipFlags(e *archiveEntry) uint16 {
+	var flags uint16
+	if !e.isDir() {
+		flags |= zipFlagDescriptor
+	}
+	if !isASCII(e.name) && utf8.ValidString(e.name) {
+		flags |= zipFlagUTF8
+	}
+	return flags
+}
+
+func zipVersion(e *archiveEntry) uint16 {
+	if e.zip64 {
+		return zipVersion45
+	}
+	return zipVersion20
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// MS-DOS 日期时间，精度 2 秒，1980 年以前的按 1980 算
+func dosDateTime(t time.Time) (uint16, uint16) {
+	if t.Year() < 1980 {
+		t = time.Date(1980, 1, 1, 0, 0, 0, 0, t.Location())
+	}
+	date := uint16(t.Day() + int(t.Month())<<5 + (t.Year()-1980)<<9)
+	clock := uint16(t.Second()/2 + t.Minute()<<5 + t.Hour()<<11)
+	return date, clock
+}
+
+func appendExtTime(b []byte, t time.Time) []byte {
+	sec := t.Unix()
+	if sec < 0 || sec > uint32max {
+		sec = 0
+	}
+	b = binary.LittleEndian.AppendUint16(b, 0x5455)
+	b = binary.LittleEndian.AppendUint16(b, 5)
+	b = append(b, 1)
+	return binary.LittleEndian.AppendUint32(b, uint32(sec))
+}
+
+func localHeader(e *archiveEntry) []byte {
+	extraLen := zipExtTimeLen
+	if e.zip64 {
+		extraLen += zip64LocalExtraLen
+	}
+	b := make([]byte, 0, zipLocalHeaderLen+len(e.name)+extraLen)
+	date, clock := dosDateTime(e.modTime)
+	b = binary.LittleEndian.AppendUint32(b, 0x04034b50)
+	b = binary.LittleEndian.AppendUint16(b, zipVersion(e))
+	b = binary.LittleEndian.AppendUint16(b, zipFlags(e))
+	b = binary.LittleEndian.AppendUint16(b, 0) // store
+	b = binary.LittleEndian.AppendUint16(b, clock)
+	b = binary.LittleEndian.AppendUint16(b, date)
+	b = binary.LittleEndian.AppendUint32(b, 0) // crc 在 data descriptor 里
+	if e.zip64 {
+		b = binary.LittleEndian.AppendUint32(b, uint32max)
+		b = binary.LittleEndian.AppendUint32(b, uint32max)
+	} else {
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint32(b, 0)
+	}
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(e.name)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(extraLen))
+	b = append(b, e.name...)
+	if e.zip64 {
+		b = binary.LittleEndian.AppendUint16(b, 0x0001)
+		b = binary.LittleEndian.AppendUint16(b, 16)
+		b = binary.LittleEndian.AppendUint64(b, 0)
+		b = binary.LittleEndian.AppendUint64(b, 0)
+	}
+	return appendExtTime(b, e.modTime)
+}
+
+func centralExtraLen(e *archiveEntry) int {
+	if e.zip64 {
+		return zip64CentralExtra + zipExtTimeLen
+	}
+	return zipExtTimeLen
+}
+
+// Unix 权限放在外部属性的高 16 位，目录再带上 MS-DOS 的目录位
+func externalAttrs(e *archiveEntry) uint32 {
+	mode := uint32(e.mode.Perm())
+	if e.isDir() {
+		return (0o040000|mode)<<16 | 0x10
+	}
+	return (0o100000 | mode) << 16
+}
+
+func appendCentralHeader(b []byte, e *archiveEntry) []byte {
+	date, clock := dosDateTime(e.modTime)
+	b = binary.LittleEndian.AppendUint32(b, 0x02014b50)
+	b = binary.LittleEndian.AppendUint16(b, zipCreatorUnix<<8|zipVersion(e))
+	b = binary.LittleEndian.AppendUint16(b, zipVersion(e))
+	b = binary.LittleEndian.AppendUint16(b, zipFlags(e))
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint16(b, clock)
+	b = binary.LittleEndian.AppendUint16(b, date)
+	b = binary.LittleEndian.AppendUint32(b, e.crc)
+	if e.zip64 {
+		b = binary.LittleEndian.AppendUint32(b, uint32max)
+		b = binary.LittleEndian.AppendUint32(b, uint32max)
+	} else {
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.size))
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.size))
+	}
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(e.name)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(centralExtraLen(e)))
+	b = binary.LittleEndian.AppendUint16(b, 0) // comment
+	b = binary.LittleEndian.AppendUint16(b, 0) // disk
+	b = binary.LittleEndian.AppendUint16(b, 0) // internal attrs
+	b = binary.LittleEndian.AppendUint32(b, externalAttrs(e))
+	if e.zip64 {
+		b = binary.LittleEndian.AppendUint32(b, uint32max)
+	} else {
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.offset))
+	}
+	b = append(b, e.name...)
+	if e.zip64 {
+		b = binary.LittleEndian.AppendUint16(b, 0x0001)
+		b = binary.LittleEndian.AppendUint16(b, 24)
+		b = binary.LittleEndian.AppendUint64(b, uint64(e.size))
+		b = binary.LittleEndian.AppendUint64(b, uint64(e.size))
+		b = binary.LittleEndian.AppendUint64(b, uint64(e.offset))
+	}
+	return appendExtTime(b, e.modTime)
+}
+
+func (z *storedZip) descriptor(e *archiveEntry) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		if err := z.ensureCRC(e); err != nil {
+			return nil, err
+		}
+		b := make([]byte, 0, descriptorLen(e))
+		b = binary.LittleEndian.AppendUint32(b, 0x08074b50)
+		b = binary.LittleEndian.AppendUint32(b, e.crc)
+		if e.zip64 {
+			b = binary.LittleEndian.AppendUint64(b, uint64(e.size))
+			return binary.LittleEndian.AppendUint64(b, uint64(e.size)), nil
+		}
+		b = binary.LittleEndian.AppendUint32(b, uint32(e.size))
+		return binary.LittleEndian.AppendUint32(b, uint32(e.size)), nil
+	}
+}
+
+func (z *storedZip) centralDirectory(cdStart, cdSize int64) ([]byte, error) {
+	b := make([]byte, 0, cdSize+zipEndLen+zip64EndLen+zip64LocatorLen)
+	for _, e := range z.entries {
+		if err := z.ensureCRC(e); err != nil {
+			return nil, err
+		}
+		b = appendCentralHeader(b, e)
+	}
+	if int64(len(b)) != cdSize {
+		return nil, fmt.Errorf("central directory size mismatch: %d != %d", len(b), cdSize)
+	}
+	n := uint64(len(z.entries))
+	if needZip64End(len(z.entries), cdStart, cdSize) {
+		end64 := cdStart + cdSize
+		b = binary.LittleEndian.AppendUint32(b, 0x06064b50)
+		b = binary.LittleEndian.AppendUint64(b, zip64EndLen-12)
+		b = binary.LittleEndian.AppendUint16(b, zipCreatorUnix<<8|zipVersion45)
+		b = binary.LittleEndian.AppendUint16(b, zipVersion45)
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint64(b, n)
+		b = binary.LittleEndian.AppendUint64(b, n)
+		b = binary.LittleEndian.AppendUint64(b, uint64(cdSize))
+		b = binary.LittleEndian.AppendUint64(b, uint64(cdStart))
+
+		b = binary.LittleEndian.AppendUint32(b, 0x07064b50)
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = binary.LittleEndian.AppendUint64(b, uint64(end64))
+		b = binary.LittleEndian.AppendUint32(b, 1)
+	}
+	b = binary.LittleEndian.AppendUint32(b, 0x06054b50)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint16(b, 0)
+	b = binary.LittleEndian.AppendUint16(b, uint16(min(n, uint16max)))
+	b = binary.LittleEndian.AppendUint16(b, uint16(min(n, uint16max)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(min(cdSize, uint32max)))
+	b = binary.LittleEndian.AppendUint32(b, uint32(min(cdStart, uint32max)))
+	return binary.LittleEndian.AppendUint16(b, 0), nil
+}
+
+// 没顺序读完的文件（续传跳过的部分）单独读一遍算 CRC
+func (z *storedZip) ensureCRC(e *archiveEntry) error {
+	if e.crcDone {
+		return nil
+	}
+	key := e.cacheKey()
+	zipCRCMu.Lock()
+	crc, ok := zipCRCCache[key]
+	zipCRCMu.Unlock()
+	if ok {
+		e.crc, e.crcDone = crc, true
+		return nil
+	}
+	f, err := os.Open(e.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := crc32.NewIEEE()
+	n, err := io.Copy(h, io.LimitReader(f, e.size))
+	if err != nil {
+		return err
+	}
+	if n != e.size {
+		return fmt.Errorf("%s changed during download", e.name)
+	}
+	e.crc, e.crcDone = h.Sum32(), true
+	storeZipCRC(key, e.crc)
+	return nil
+}
+
+func storeZipCRC(key string, crc uint32) {
+	zipCRCMu.Lock()
+	defer zipCRCMu.Unlock()
+	if len(zipCRCCache) >= zipCRCCacheMax {
+		zipCRCCache = make(map[string]uint32)
+	}
+	zipCRCCache[key] = crc
+}
+
+func (z *storedZip) readData(e *archiveEntry, p []byte, off int64) (int, error) {
+	if e.data != nil {
+		return copy(p, e.data[off:]), nil
+	}
+	if z.curPath != e.path {
+		z.closeFile()
+		f, err := os.Open(e.path)
+		if err != nil {
+			return 0, err
+		}
+		z.cur, z.curPath = f, e.path
+	}
+	n, err := z.cur.ReadAt(p, off)
+	if n < len(p) {
+		if err == nil || errors.Is(err, io.EOF) {
+			err = fmt.Errorf("%s changed during download", e.name)
+		}
+		return n, err
+	}
+	if !e.crcDone && off == e.crcPos {
+		e.crc = crc32.Update(e.crc, crc32.IEEETable, p[:n])
+		e.crcPos += int64(n)
+		if e.crcPos == e.size {
+			e.crcDone = true
+			storeZipCRC(e.cacheKey(), e.crc)
+		}
+	}
+	return n, nil
+}
+
+func (z *storedZip) Read(p []byte) (int, error) {
+	if z.pos >= z.size {
+		return 0, io.EOF
+	}
+	i := sort.Search(len(z.segs), func(i int) bool { return z.segs[i].start+z.segs[i].size > z.pos })
+	seg := z.segs[i]
+	off := z.pos - seg.start
+	if rest := seg.size - off; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	var n int
+	var err error
+	if seg.entry != nil {
+		n, err = z.readData(seg.entry, p, off)
+	} else {
+		if seg.data == nil {
+			if seg.data, err = seg.build(); err != nil {
+				return 0, err
+			}
+		}
+		n = copy(p, seg.data[off:])
+	}
+	z.pos += int64(n)
+	return n, err
+}
+
+func (z *storedZip) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += z.pos
+	case io.SeekEnd:
+		offset += z.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	z.pos = offset
+	return offset, nil
+}
+
+func (z *storedZip) closeFile() {
+	if z.cur != nil {
+		_ = z.cur.Close()
+		z.cur, z.curPath = nil, ""
+	}
+}
+
+// Range、If-Range、HEAD 都交给 http.ServeContent
+func serveStoredZip(w http.ResponseWriter, r *http.Request, c *archiveCollector, filename string) {
+	z := newStoredZip(c)
+	defer z.closeFile()
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s"`, filename))
+	w.Header().Set("ETag", z.etag)
+	w.Header().Set(skippedTrailer, strconv.Itoa(len(c.skipped)))
+	http.ServeContent(w, r, filename, z.modTime, z)
+}